@@ -0,0 +1,277 @@
+package plock
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// visibleReaders is the table biased readers publish themselves into,
+// shared by every BiasedPMutex. A slot holds the lock its reader is reading
+const visibleReadersSize = 4096
+
+var visibleReaders [visibleReadersSize]unsafe.Pointer
+
+// biasInhibitFactor is how many times longer than a revocation took the bias
+// stays disabled afterwards; this bounds the time writers spend revoking to
+// about 10% of the total
+const biasInhibitFactor = 9
+
+// epoch is the base of the monotonic clock used for inhibiting bias
+var epoch = time.Now()
+
+func nanotime() int64 {
+	return int64(time.Since(epoch))
+}
+
+// BiasedPMutex is a PMutex that biases towards readers, using the BRAVO
+// technique (Dice & Kogan, "BRAVO - Biased Locking for Reader-Writer Locks").
+// While the bias is on, readers only publish themselves into a slot of a
+// global table, without touching the lock word. Writers revoke the bias and
+// wait for the slots referring to the lock to empty. Since revoking is
+// expensive, the bias then stays off for a period proportional to how long
+// the revocation took, and is turned back on by the next reader after that.
+//
+// Seek, Write and Atomic Write Locks behave exactly as they do on PMutex.
+// Read Locks return an RToken, which must be passed back when releasing or
+// upgrading them.
+//
+// The zero value is an unlocked BiasedPMutex, whose bias is turned on by the
+// first reader
+type BiasedPMutex struct {
+	// inhibitUntil is the nanotime before which bias may not be turned on.
+	// It is accessed atomically, so it comes first to be 64 bit aligned on
+	// 32 bit platforms
+	inhibitUntil int64
+
+	// bias is one of the bias* constants below. Biased readers only read
+	// bias, so it is kept off the cache line of the lock word
+	bias int32
+	_    [cacheLineSize - 12]byte
+
+	p PMutex
+}
+
+const (
+	// biasOff means readers must use the lock word, and no reader is left
+	// in the visible readers table
+	biasOff int32 = iota
+	// biasOn means readers may use the visible readers table
+	biasOn
+	// biasRevoking means a writer is waiting for readers to leave the
+	// visible readers table
+	biasRevoking
+)
+
+// Biased reports whether readers currently bypass the lock word. Like State,
+// this reflects a single moment in time
+func (m *BiasedPMutex) Biased() bool {
+	return atomic.LoadInt32(&m.bias) == biasOn
+}
+
+// slot picks the visible readers slot for the calling goroutine, from the
+// address of the lock and of the goroutine's stack
+func (m *BiasedPMutex) slot() RToken {
+	var x byte
+	h := uint64(uintptr(unsafe.Pointer(m))) ^ uint64(uintptr(unsafe.Pointer(&x))>>10)
+	h *= 0x9E3779B97F4A7C15
+
+	return RToken((h >> 32) % visibleReadersSize)
+}
+
+// tryBiasedRLock attempts to publish a Read Lock in the visible readers table
+func (m *BiasedPMutex) tryBiasedRLock() (RToken, bool) {
+	if atomic.LoadInt32(&m.bias) != biasOn {
+		return centralRToken, false
+	}
+
+	t := m.slot()
+	if !atomic.CompareAndSwapPointer(&visibleReaders[t], nil, unsafe.Pointer(m)) {
+		return centralRToken, false
+	}
+
+	// this pairs with revoke clearing bias before scanning the table: either
+	// it sees our slot, or we see the bias is gone
+	if atomic.LoadInt32(&m.bias) == biasOn {
+		return t, true
+	}
+	atomic.StorePointer(&visibleReaders[t], nil)
+
+	return centralRToken, false
+}
+
+// rlocked is called once a Read Lock is held on the lock word, and turns the
+// bias back on if it has been off for long enough
+func (m *BiasedPMutex) rlocked() {
+	if atomic.LoadInt32(&m.bias) == biasOff && nanotime() >= atomic.LoadInt64(&m.inhibitUntil) {
+		atomic.CompareAndSwapInt32(&m.bias, biasOff, biasOn)
+	}
+}
+
+// biasedReaders reports whether any reader of m is in the visible readers
+// table
+func (m *BiasedPMutex) biasedReaders() bool {
+	for i := range visibleReaders {
+		if atomic.LoadPointer(&visibleReaders[i]) == unsafe.Pointer(m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// revoke turns the bias off and waits for every biased reader to leave. The
+// caller must hold the Write bit of the lock word, which keeps the bias from
+// being turned back on
+func (m *BiasedPMutex) revoke() {
+	if !atomic.CompareAndSwapInt32(&m.bias, biasOn, biasRevoking) {
+		// either the bias is off, or another atomic writer is revoking it
+		for atomic.LoadInt32(&m.bias) != biasOff {
+			runtime.Gosched()
+		}
+		return
+	}
+
+	start := nanotime()
+	for m.biasedReaders() {
+		runtime.Gosched()
+	}
+	now := nanotime()
+	atomic.StoreInt64(&m.inhibitUntil, now+(now-start)*biasInhibitFactor)
+	atomic.StoreInt32(&m.bias, biasOff)
+}
+
+// TryRLock attempts to acquire a Read Lock without blocking. If ok is true,
+// the lock was acquired and t must be passed to RUnlock
+func (m *BiasedPMutex) TryRLock() (t RToken, ok bool) {
+	if t, ok := m.tryBiasedRLock(); ok {
+		return t, true
+	}
+	if !m.p.TryRLock() {
+		return centralRToken, false
+	}
+	m.rlocked()
+
+	return centralRToken, true
+}
+
+// RLock acquires a Read Lock, blocking while a writer is present. The
+// returned RToken must be passed to RUnlock, or to an upgrade
+func (m *BiasedPMutex) RLock() RToken {
+	if t, ok := m.tryBiasedRLock(); ok {
+		return t
+	}
+	m.p.RLock()
+	m.rlocked()
+
+	return centralRToken
+}
+
+// RUnlock releases the Read Lock identified by t
+func (m *BiasedPMutex) RUnlock(t RToken) {
+	if t == centralRToken {
+		m.p.RUnlock()
+		return
+	}
+	atomic.StorePointer(&visibleReaders[t], nil)
+}
+
+// RToS upgrades the Read Lock identified by t to a Seek Lock
+func (m *BiasedPMutex) RToS(t RToken) {
+	if t == centralRToken {
+		m.p.RToS()
+		return
+	}
+
+	// the Seek Lock carries its own Read Lock on the lock word, so the slot
+	// can be let go once it is held
+	m.p.SLock()
+	atomic.StorePointer(&visibleReaders[t], nil)
+}
+
+// TrySLock attempts to acquire a Seek Lock without blocking. It returns true
+// if the lock was acquired
+func (m *BiasedPMutex) TrySLock() bool {
+	return m.p.TrySLock()
+}
+
+// SLock acquires a Seek Lock. Biased readers are not affected
+func (m *BiasedPMutex) SLock() {
+	m.p.SLock()
+}
+
+// SUnlock releases an existing Seek Lock
+func (m *BiasedPMutex) SUnlock() {
+	m.p.SUnlock()
+}
+
+// SToR downgrades an existing Seek Lock to a Read Lock, returning its RToken
+func (m *BiasedPMutex) SToR() RToken {
+	m.p.SToR()
+	return centralRToken
+}
+
+// SToW upgrades an existing Seek Lock to a Write Lock, revoking the bias and
+// waiting for all readers to leave
+func (m *BiasedPMutex) SToW() {
+	m.p.SToW()
+	m.revoke()
+}
+
+// TryWLock attempts to acquire a Write Lock without blocking. It returns true
+// if the lock was acquired
+func (m *BiasedPMutex) TryWLock() bool {
+	if !m.p.TryWLock() {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&m.bias, biasOn, biasRevoking) {
+		return true
+	}
+
+	if m.biasedReaders() {
+		// readers that saw the revocation are waiting on the lock word, so
+		// the bias can safely be given back
+		atomic.StoreInt32(&m.bias, biasOn)
+		m.p.WUnlock()
+		return false
+	}
+	atomic.StoreInt32(&m.bias, biasOff)
+
+	return true
+}
+
+// WLock acquires a Write Lock, revoking the bias and blocking until all
+// readers leave
+func (m *BiasedPMutex) WLock() {
+	m.p.WLock()
+	m.revoke()
+}
+
+// WUnlock releases an existing Write Lock
+func (m *BiasedPMutex) WUnlock() {
+	m.p.WUnlock()
+}
+
+// WToR downgrades an existing Write Lock to a Read Lock, returning its RToken
+func (m *BiasedPMutex) WToR() RToken {
+	m.p.WToR()
+	return centralRToken
+}
+
+// WToS downgrades an existing Write Lock to a Seek Lock
+func (m *BiasedPMutex) WToS() {
+	m.p.WToS()
+}
+
+// ALock acquires an Atomic Write Lock, revoking the bias and blocking until
+// all readers leave
+func (m *BiasedPMutex) ALock() {
+	m.p.ALock()
+	m.revoke()
+}
+
+// AUnlock releases an Atomic Write Lock
+func (m *BiasedPMutex) AUnlock() {
+	m.p.AUnlock()
+}
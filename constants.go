@@ -21,3 +21,27 @@ const (
 	plock64WL1   uint64 = 0x0000000400000000
 	plock64WLAny uint64 = 0xFFFFFFFC00000000
 )
+
+// Lock word layout of a LockNode. Every mode has its own count, so that
+// compatibility can be checked with a single mask
+// nolint: megacheck, varcheck
+const (
+	treeIS1     uint64 = 0x0000000000000001
+	treeISAny   uint64 = 0x0000000000003FFF
+	treeIX1     uint64 = 0x0000000000004000
+	treeIXAny   uint64 = 0x000000000FFFC000
+	treeS1      uint64 = 0x0000000010000000
+	treeSAny    uint64 = 0x000003FFF0000000
+	treeA1      uint64 = 0x0000040000000000
+	treeAAny    uint64 = 0x00FFFC0000000000
+	treeSeek1   uint64 = 0x0100000000000000
+	treeSeekAny uint64 = 0x0300000000000000
+	treeSIX1    uint64 = 0x0400000000000000
+	treeSIXAny  uint64 = 0x0C00000000000000
+	treeX1      uint64 = 0x1000000000000000
+	treeXAny    uint64 = 0x3000000000000000
+)
+
+// MaxTreeHolders is the most holders a LockNode can count in each of TreeIS,
+// TreeIX, TreeS and TreeAtomic
+const MaxTreeHolders = int(treeISAny)
@@ -0,0 +1,213 @@
+package plock
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ContentionProfileName is the name of the runtime/pprof profile of sampled
+// contended acquisitions, available once SetContentionProfileRate has
+// enabled it
+const ContentionProfileName = "plock-contention"
+
+// contentionProfileMax is the number of recent samples kept in the
+// runtime/pprof profile, which can only count samples and so can't aggregate
+// them
+const contentionProfileMax = 4096
+
+// contentionRecord is the total wait of the samples with one stack
+type contentionRecord struct {
+	count int64
+	delay int64
+	stack []uintptr
+}
+
+// byDelay sorts records by decreasing delay
+type byDelay []contentionRecord
+
+func (r byDelay) Len() int           { return len(r) }
+func (r byDelay) Less(i, j int) bool { return r[i].delay > r[j].delay }
+func (r byDelay) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// contentionSample is an entry of the runtime/pprof profile
+type contentionSample struct{ delay int64 }
+
+var contention = struct {
+	rate int64
+
+	mu      sync.Mutex
+	records map[[maxStackDepth]uintptr]*contentionRecord
+	profile *pprof.Profile
+	recent  []*contentionSample
+	next    int
+}{
+	records: map[[maxStackDepth]uintptr]*contentionRecord{},
+}
+
+// SetContentionProfileRate controls the fraction of contended PMutex
+// acquisitions that are sampled: on average 1/rate of the acquisitions and
+// upgrades that had to wait are recorded, with their stack and how long they
+// waited. A rate of 0 turns off profiling, and a rate of 1 records every
+// contended acquisition. It returns the previous rate. Uncontended
+// acquisitions are never affected.
+//
+// Samples are available through WriteContentionProfile and, as counts only,
+// through the runtime/pprof profile named by ContentionProfileName
+func SetContentionProfileRate(rate int) int {
+	if rate > 0 {
+		contention.mu.Lock()
+		if contention.profile == nil {
+			contention.profile = pprof.NewProfile(ContentionProfileName)
+		}
+		contention.mu.Unlock()
+	}
+	if rate < 0 {
+		rate = 0
+	}
+
+	return int(atomic.SwapInt64(&contention.rate, int64(rate)))
+}
+
+// waitStart follows the waits of one blocking acquisition or upgrade
+type waitStart struct {
+	// mode is the mode being acquired or upgraded to, and from the mode
+	// being upgraded from, or Unlocked
+	mode Mode
+	from Mode
+	// draining is set once the lock has been claimed, and the acquisition
+	// only waits for readers to leave
+	draining bool
+	// at is when the acquisition first waited, by nanotime, if it is timed
+	at int64
+	// waits counts the failed attempts
+	waits int
+	// sampled is set if the contention profile records the acquisition
+	sampled bool
+	// region is the execution trace region of the wait, if any
+	region traceRegion
+}
+
+// wait is called by a blocking acquisition of p before every wait. The first
+// wait is timed if the acquisition is sampled or p collects Stats
+func (w *waitStart) wait(p *PMutex) {
+	if w.waits == 0 {
+		rate := atomic.LoadInt64(&contention.rate)
+		w.sampled = rate == 1 || (rate > 1 && rand.Int63n(rate) == 0)
+		if w.sampled || (p.opts != nil && p.opts.stats != nil) {
+			w.at = nanotime()
+		}
+		p.waitBegan(w)
+	}
+	w.waits++
+}
+
+// drain is called by a blocking acquisition of p once it has claimed the lock,
+// before waiting for the readers to leave
+func (w *waitStart) drain(p *PMutex) {
+	w.draining = true
+	if w.waits != 0 {
+		p.waitDraining()
+	}
+}
+
+// canceled returns ctx.Err() if ctx is done, in which case the acquisition
+// stops waiting for p
+func (w *waitStart) canceled(p *PMutex, ctx context.Context) error {
+	err := canceled(ctx)
+	if err != nil && w.waits != 0 {
+		p.waitEnded(w)
+	}
+
+	return err
+}
+
+// recordContention records a sampled acquisition. It is called by the hooks
+// of the unexported method doing the acquisition, so the recorded stack skips
+// both and starts at the exported PMutex method that blocked
+func recordContention(w *waitStart) {
+	delay := nanotime() - w.at
+
+	var key [maxStackDepth]uintptr
+	n := runtime.Callers(4, key[:])
+	sample := &contentionSample{delay: delay}
+
+	contention.mu.Lock()
+	defer contention.mu.Unlock()
+
+	r := contention.records[key]
+	if r == nil {
+		r = &contentionRecord{stack: append([]uintptr(nil), key[:n]...)}
+		contention.records[key] = r
+	}
+	r.count++
+	r.delay += delay
+
+	if len(contention.recent) < contentionProfileMax {
+		contention.recent = append(contention.recent, sample)
+	} else {
+		contention.profile.Remove(contention.recent[contention.next])
+		contention.recent[contention.next] = sample
+		contention.next = (contention.next + 1) % contentionProfileMax
+	}
+	contention.profile.Add(sample, 2)
+}
+
+// WriteContentionProfile writes the total time spent waiting by sampled
+// contended acquisitions, and their number, for every stack that waited. The
+// stacks start at the PMutex method that waited, so waits are broken down by
+// mode and conversion. The output is in the text format of the runtime's
+// mutex profile, which go tool pprof reads
+func WriteContentionProfile(w io.Writer) error {
+	contention.mu.Lock()
+	records := make([]contentionRecord, 0, len(contention.records))
+	for _, r := range contention.records {
+		records = append(records, *r)
+	}
+	contention.mu.Unlock()
+
+	sort.Sort(byDelay(records))
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "--- contention:\ncycles/second=%d\nsampling period=%d\n", time.Second.Nanoseconds(), atomic.LoadInt64(&contention.rate))
+	for _, r := range records {
+		fmt.Fprintf(b, "%d %d @", r.delay, r.count)
+		for _, pc := range r.stack {
+			fmt.Fprintf(b, " %#x", pc)
+		}
+		b.WriteString("\n")
+
+		frames := runtime.CallersFrames(r.stack)
+		for {
+			f, more := frames.Next()
+			fmt.Fprintf(b, "#\t%#x\t%s+%#x\t%s:%d\n", f.PC, f.Function, f.PC-f.Entry, f.File, f.Line)
+			if !more {
+				break
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.Flush()
+}
+
+// ResetContentionProfile discards every sample recorded so far
+func ResetContentionProfile() {
+	contention.mu.Lock()
+	defer contention.mu.Unlock()
+
+	contention.records = map[[maxStackDepth]uintptr]*contentionRecord{}
+	for _, s := range contention.recent {
+		contention.profile.Remove(s)
+	}
+	contention.recent = nil
+	contention.next = 0
+}
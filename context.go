@@ -0,0 +1,18 @@
+package plock
+
+import "context"
+
+// canceled returns ctx.Err() if ctx is done, and nil otherwise. A nil ctx is
+// never done; this is what the blocking, context-free methods pass
+func canceled(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
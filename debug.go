@@ -0,0 +1,23 @@
+package plock
+
+import "fmt"
+
+// hold is a kind of lock that an unlock or conversion requires the caller to
+// hold. Builds with the plock_debug tag check for it in the lock word
+type hold int
+
+const (
+	holdRead hold = iota
+	holdSeek
+	holdWrite
+	holdAtomic
+)
+
+var holdNames = [...]string{"Read", "Seek", "Write", "Atomic Write"}
+
+// misuse describes a call to op that required a lock of kind h, made while
+// the lock was in state s
+func misuse(op string, h hold, s State) string {
+	return fmt.Sprintf("plock: %s without a %s Lock held (mode: %v; readers: %d; seeker: %t; writers: %d)",
+		op, holdNames[h], s.Mode, s.Readers, s.Seeker, s.Writers)
+}
@@ -0,0 +1,8 @@
+//go:build plock_debug
+// +build plock_debug
+
+package plock
+
+// debug makes every unlock and conversion check that the lock word holds the
+// lock it requires, panicking on misuse. Enabled by the plock_debug tag
+const debug = true
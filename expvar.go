@@ -0,0 +1,85 @@
+package plock
+
+import (
+	"errors"
+	"expvar"
+	"sort"
+	"sync"
+)
+
+// ExpvarName is the expvar variable that published Stats appear under, as a
+// JSON object of StatsSnapshots keyed by name
+const ExpvarName = "plock"
+
+// published holds the Stats published by name
+var published struct {
+	mu    sync.Mutex
+	stats map[string]*Stats
+	once  sync.Once
+}
+
+// Publish makes s available by name to expvar, under ExpvarName, and to
+// StatsHandler. Publish fails if name is already published
+func (s *Stats) Publish(name string) error {
+	published.once.Do(func() {
+		expvar.Publish(ExpvarName, expvar.Func(publishedSnapshots))
+	})
+
+	published.mu.Lock()
+	defer published.mu.Unlock()
+	if _, ok := published.stats[name]; ok {
+		return errors.New("plock: stats " + name + " are already published")
+	}
+	if published.stats == nil {
+		published.stats = make(map[string]*Stats)
+	}
+	published.stats[name] = s
+
+	return nil
+}
+
+// Unpublish removes s from every name it was published under
+func (s *Stats) Unpublish() {
+	published.mu.Lock()
+	defer published.mu.Unlock()
+	for name, p := range published.stats {
+		if p == s {
+			delete(published.stats, name)
+		}
+	}
+}
+
+// namedStats is a published Stats
+type namedStats struct {
+	name  string
+	stats *Stats
+}
+
+// byName sorts published Stats by name
+type byName []namedStats
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].name < s[j].name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// publishedStats returns the published Stats, sorted by name
+func publishedStats() []namedStats {
+	published.mu.Lock()
+	all := make([]namedStats, 0, len(published.stats))
+	for name, s := range published.stats {
+		all = append(all, namedStats{name, s})
+	}
+	published.mu.Unlock()
+
+	sort.Sort(byName(all))
+	return all
+}
+
+func publishedSnapshots() interface{} {
+	snaps := make(map[string]StatsSnapshot)
+	for _, n := range publishedStats() {
+		snaps[n.name] = n.stats.Snapshot()
+	}
+
+	return snaps
+}
@@ -0,0 +1,202 @@
+package plock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Fairness is a policy deciding the order in which blocked goroutines are
+// admitted to a PMutex. Fairness only applies to the blocking acquisitions
+// (RLock, SLock, WLock, ALock and their Context variants): Try* methods and
+// upgrades never wait their turn, since an upgrade queued behind a writer that
+// is waiting for the upgrader's own Read Lock could never complete.
+//
+// Like sync.RWMutex, a goroutine must not acquire a Read Lock it already holds
+// under any policy other than Unfair; it may be queued behind a writer waiting
+// for it to leave
+type Fairness int
+
+const (
+	// Unfair is the default: waiters race for the lock, and any of them can
+	// lose indefinitely. Once a writer has started waiting for readers to
+	// leave, new readers are held off
+	Unfair Fairness = iota
+
+	// ReaderPreferring makes writers (WLock and ALock) wait until no readers
+	// are present before they announce themselves. A reader is therefore
+	// only ever delayed by writers that have already acquired the lock, while
+	// writers can starve under a continuous stream of overlapping readers
+	ReaderPreferring
+
+	// WriterPreferring makes readers (RLock and SLock) wait while any writer
+	// is waiting. A writer is delayed at most by the holders present when it
+	// arrived and by other writers, while readers can starve under a
+	// continuous stream of writers
+	WriterPreferring
+
+	// FIFO admits waiters strictly in arrival order, whatever their mode: a
+	// waiter is admitted once every waiter that arrived before it has been
+	// admitted, so no waiter can starve. Consecutive readers still hold the
+	// lock together. Every blocking acquisition takes an internal mutex
+	FIFO
+)
+
+func (f Fairness) String() string {
+	switch f {
+	case Unfair:
+		return "Unfair"
+	case ReaderPreferring:
+		return "ReaderPreferring"
+	case WriterPreferring:
+		return "WriterPreferring"
+	case FIFO:
+		return "FIFO"
+	}
+	return "Fairness(?)"
+}
+
+// WithFairness sets the policy used to order waiters
+func WithFairness(f Fairness) Option {
+	return func(o *options) {
+		o.fair = nil
+		if f != Unfair {
+			o.fair = &fairness{policy: f}
+		}
+	}
+}
+
+// role distinguishes acquisitions that let readers in from those that don't
+type role uint8
+
+const (
+	roleReader role = iota
+	roleWriter
+)
+
+type fairness struct {
+	policy Fairness
+
+	// writers is the number of writers waiting under WriterPreferring
+	writers int32
+
+	// queue holds a channel for each FIFO waiter; the head's channel is
+	// closed when it is its turn
+	mu    sync.Mutex
+	queue []chan struct{}
+}
+
+// enter is called before a blocking acquisition starts. If it returns nil,
+// leave must be called once the acquisition is over, whether it succeeded or
+// not
+func (p *PMutex) enter(ctx context.Context, r role) error {
+	if p.opts == nil || p.opts.fair == nil {
+		return nil
+	}
+	return p.opts.fair.enter(ctx, p, r)
+}
+
+func (p *PMutex) leave(r role) {
+	if p.opts == nil || p.opts.fair == nil {
+		return
+	}
+	p.opts.fair.leave(p, r)
+}
+
+func (f *fairness) enter(ctx context.Context, p *PMutex, r role) error {
+	switch f.policy {
+	case ReaderPreferring:
+		if r == roleWriter {
+			return p.waitFor(ctx, wantNoReaders)
+		}
+	case WriterPreferring:
+		if r == roleWriter {
+			atomic.AddInt32(&f.writers, 1)
+			return nil
+		}
+		return p.waitFor(ctx, wantNoQueuedWriters)
+	case FIFO:
+		return f.enqueue(ctx)
+	}
+
+	return nil
+}
+
+func (f *fairness) leave(p *PMutex, r role) {
+	switch f.policy {
+	case WriterPreferring:
+		if r == roleWriter && atomic.AddInt32(&f.writers, -1) == 0 {
+			p.wake()
+		}
+	case FIFO:
+		f.mu.Lock()
+		f.dequeue()
+		f.mu.Unlock()
+	}
+}
+
+// waitFor blocks until the lock is ready to grant w, or ctx is done
+func (p *PMutex) waitFor(ctx context.Context, w want) error {
+	for i := 0; ; i++ {
+		gen := p.generation()
+		if p.ready(w) {
+			return nil
+		}
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		p.wait(ctx, w, i, gen)
+	}
+}
+
+func (f *fairness) enqueue(ctx context.Context) error {
+	ch := make(chan struct{})
+
+	f.mu.Lock()
+	f.queue = append(f.queue, ch)
+	if len(f.queue) == 1 {
+		close(ch)
+	}
+	f.mu.Unlock()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-done:
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-ch:
+		// our turn came along with the cancellation; pass it on
+		f.dequeue()
+		return ctx.Err()
+	default:
+	}
+	for i := range f.queue {
+		if f.queue[i] == ch {
+			copy(f.queue[i:], f.queue[i+1:])
+			f.queue[len(f.queue)-1] = nil
+			f.queue = f.queue[:len(f.queue)-1]
+			break
+		}
+	}
+	return ctx.Err()
+}
+
+// dequeue removes the head of the queue and hands the turn to the next
+// waiter. mu must be held
+func (f *fairness) dequeue() {
+	copy(f.queue, f.queue[1:])
+	f.queue[len(f.queue)-1] = nil
+	f.queue = f.queue[:len(f.queue)-1]
+	if len(f.queue) != 0 {
+		close(f.queue[0])
+	}
+}
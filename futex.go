@@ -0,0 +1,106 @@
+package plock
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// futexWaiters is set in the lock word while goroutines sleep on it. Bit 0 is
+// below the reader count in both lock word layouts, so no lock operation
+// touches it
+const futexWaiters uint32 = 1
+
+// futexPoll bounds each sleep of a waiter whose context may be canceled, as
+// cancellation can't interrupt the sleep
+const futexPoll = 10 * time.Millisecond
+
+// bigEndian is true if the high half of a 64 bit word comes first in memory
+var bigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
+// WithFutex makes contended goroutines sleep in the kernel on the lock word
+// itself, rather than retrying. Releases only enter the kernel when a waiter
+// has marked the word, so uncontended use is unaffected. As the waiters are
+// recorded in the lock word, this also works for a lock shared with other
+// processes, provided they all use WithFutex. Each sleeping goroutine
+// occupies an OS thread.
+//
+// Futexes are only available on Linux; elsewhere, waiters yield to the
+// scheduler as with the default strategy. Parking takes precedence over
+// WithFutex, which takes precedence over any WaitStrategy
+func WithFutex() Option {
+	return func(o *options) {
+		o.futex = true
+	}
+}
+
+// futexWord returns the address of the low 32 bits of the lock word, which
+// hold futexWaiters
+func (p *PMutex) futexWord() *uint32 {
+	addr := unsafe.Pointer(p.addr())
+	if SharedWordSize == 8 && bigEndian {
+		addr = unsafe.Pointer(uintptr(addr) + 4)
+	}
+
+	return (*uint32)(addr)
+}
+
+// sleep marks the lock word as having waiters and sleeps until it changes,
+// unless w is satisfied in the meantime
+func (p *PMutex) sleep(ctx context.Context, w want) {
+	if w == wantNoQueuedWriters {
+		// not a property of the lock word, so a release won't signal it
+		runtime.Gosched()
+		return
+	}
+
+	var timeout time.Duration
+	if ctx != nil && ctx.Done() != nil {
+		timeout = futexPoll
+		if d, ok := ctx.Deadline(); ok {
+			until := d.Sub(time.Now())
+			if until <= 0 {
+				return
+			}
+			if until < timeout {
+				timeout = until
+			}
+		}
+	}
+
+	f := p.futexWord()
+	for {
+		v := atomic.LoadUint32(f)
+		if v&futexWaiters == 0 && !atomic.CompareAndSwapUint32(f, v, v|futexWaiters) {
+			continue
+		}
+
+		// a release between the failed attempt and setting futexWaiters
+		// would not have woken us
+		if p.grantable(w) {
+			return
+		}
+		futexWait(f, v|futexWaiters, timeout, p.opts.word != nil)
+		return
+	}
+}
+
+// wakeSleepers wakes every goroutine sleeping on the lock word, if any
+func (p *PMutex) wakeSleepers() {
+	f := p.futexWord()
+	for {
+		v := atomic.LoadUint32(f)
+		if v&futexWaiters == 0 {
+			return
+		}
+		if atomic.CompareAndSwapUint32(f, v, v&^futexWaiters) {
+			futexWake(f, p.opts.word != nil)
+			return
+		}
+	}
+}
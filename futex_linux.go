@@ -0,0 +1,42 @@
+package plock
+
+import (
+	"math"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	futexWaitOp  = 0
+	futexWakeOp  = 1
+	futexPrivate = 128
+)
+
+// futexWait sleeps until addr is woken, provided it still holds val. A
+// timeout of 0 sleeps indefinitely. Locks in shared memory must not use
+// private futexes, which are keyed by the address within this process
+func futexWait(addr *uint32, val uint32, timeout time.Duration, shared bool) {
+	op := uintptr(futexWaitOp)
+	if !shared {
+		op |= futexPrivate
+	}
+
+	var ts *syscall.Timespec
+	if timeout != 0 {
+		t := syscall.NsecToTimespec(timeout.Nanoseconds())
+		ts = &t
+	}
+
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), op, uintptr(val), uintptr(unsafe.Pointer(ts)), 0, 0)
+}
+
+// futexWake wakes every goroutine sleeping on addr
+func futexWake(addr *uint32, shared bool) {
+	op := uintptr(futexWakeOp)
+	if !shared {
+		op |= futexPrivate
+	}
+
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), op, math.MaxInt32, 0, 0, 0)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package plock
+
+import (
+	"runtime"
+	"time"
+)
+
+// futexWait yields, as futexes are not available
+func futexWait(addr *uint32, val uint32, timeout time.Duration, shared bool) {
+	runtime.Gosched()
+}
+
+// futexWake does nothing, as futexWait never sleeps
+func futexWake(addr *uint32, shared bool) {}
@@ -0,0 +1,184 @@
+package plock
+
+// Guards are tokens for a lock held on a PMutex. Each guard type only has
+// methods for the transitions that are legal from the mode it represents, so
+// releasing or converting a lock that isn't held fails to compile. A guard
+// is a single pointer and is passed by value.
+//
+// A guard is consumed by Release and by every transition, which return the
+// guard for the new mode, if any. Using a guard after it has been consumed,
+// or the zero value guard, is as invalid as unlocking a lock twice
+
+// ReadGuard is a held Read Lock
+type ReadGuard struct{ p *PMutex }
+
+// SeekGuard is a held Seek Lock
+type SeekGuard struct{ p *PMutex }
+
+// WriteGuard is a held Write Lock
+type WriteGuard struct{ p *PMutex }
+
+// AtomicGuard is a held Atomic Write Lock
+type AtomicGuard struct{ p *PMutex }
+
+// RGuard acquires a Read Lock, returning its guard
+func (p *PMutex) RGuard() ReadGuard {
+	p.RLock()
+	return ReadGuard{p}
+}
+
+// TryRGuard attempts to acquire a Read Lock without blocking. The guard is
+// only valid if ok is true
+func (p *PMutex) TryRGuard() (g ReadGuard, ok bool) {
+	if !p.TryRLock() {
+		return ReadGuard{}, false
+	}
+	return ReadGuard{p}, true
+}
+
+// SGuard acquires a Seek Lock, returning its guard
+func (p *PMutex) SGuard() SeekGuard {
+	p.SLock()
+	return SeekGuard{p}
+}
+
+// TrySGuard attempts to acquire a Seek Lock without blocking. The guard is
+// only valid if ok is true
+func (p *PMutex) TrySGuard() (g SeekGuard, ok bool) {
+	if !p.TrySLock() {
+		return SeekGuard{}, false
+	}
+	return SeekGuard{p}, true
+}
+
+// WGuard acquires a Write Lock, returning its guard
+func (p *PMutex) WGuard() WriteGuard {
+	p.WLock()
+	return WriteGuard{p}
+}
+
+// TryWGuard attempts to acquire a Write Lock without blocking. The guard is
+// only valid if ok is true
+func (p *PMutex) TryWGuard() (g WriteGuard, ok bool) {
+	if !p.TryWLock() {
+		return WriteGuard{}, false
+	}
+	return WriteGuard{p}, true
+}
+
+// AGuard acquires an Atomic Write Lock, returning its guard
+func (p *PMutex) AGuard() AtomicGuard {
+	p.ALock()
+	return AtomicGuard{p}
+}
+
+// TryAGuard attempts to acquire an Atomic Write Lock without blocking. The
+// guard is only valid if ok is true
+func (p *PMutex) TryAGuard() (g AtomicGuard, ok bool) {
+	if !p.TryALock() {
+		return AtomicGuard{}, false
+	}
+	return AtomicGuard{p}, true
+}
+
+// Release releases the Read Lock
+func (g ReadGuard) Release() {
+	g.p.RUnlock()
+}
+
+// UpgradeToSeek upgrades the Read Lock to a Seek Lock, as PMutex.RToS
+func (g ReadGuard) UpgradeToSeek() SeekGuard {
+	g.p.RToS()
+	return SeekGuard(g)
+}
+
+// TryUpgradeToSeek attempts to upgrade the Read Lock to a Seek Lock without
+// blocking. If ok is false, g is still held and the returned guard is invalid
+func (g ReadGuard) TryUpgradeToSeek() (s SeekGuard, ok bool) {
+	if !g.p.TryRToS() {
+		return SeekGuard{}, false
+	}
+	return SeekGuard(g), true
+}
+
+// UpgradeToWrite upgrades the Read Lock to a Write Lock, as PMutex.RToW,
+// which deadlocks if another reader attempts the same
+func (g ReadGuard) UpgradeToWrite() WriteGuard {
+	g.p.RToW()
+	return WriteGuard(g)
+}
+
+// TryUpgradeToWrite attempts to upgrade the Read Lock to a Write Lock without
+// blocking. If ok is false, g is still held and the returned guard is invalid
+func (g ReadGuard) TryUpgradeToWrite() (w WriteGuard, ok bool) {
+	if !g.p.TryRToW() {
+		return WriteGuard{}, false
+	}
+	return WriteGuard(g), true
+}
+
+// UpgradeToAtomic upgrades the Read Lock to an Atomic Write Lock, as
+// PMutex.RToA
+func (g ReadGuard) UpgradeToAtomic() AtomicGuard {
+	g.p.RToA()
+	return AtomicGuard(g)
+}
+
+// TryUpgradeToAtomic attempts to upgrade the Read Lock to an Atomic Write Lock
+// without blocking. If ok is false, g is still held and the returned guard is
+// invalid
+func (g ReadGuard) TryUpgradeToAtomic() (a AtomicGuard, ok bool) {
+	if !g.p.TryRToA() {
+		return AtomicGuard{}, false
+	}
+	return AtomicGuard(g), true
+}
+
+// Release releases the Seek Lock
+func (g SeekGuard) Release() {
+	g.p.SUnlock()
+}
+
+// Upgrade upgrades the Seek Lock to a Write Lock, waiting for readers to
+// leave
+func (g SeekGuard) Upgrade() WriteGuard {
+	g.p.SToW()
+	return WriteGuard(g)
+}
+
+// TryUpgrade attempts to upgrade the Seek Lock to a Write Lock without
+// blocking. If ok is false, g is still held and the returned guard is invalid
+func (g SeekGuard) TryUpgrade() (w WriteGuard, ok bool) {
+	if !g.p.TrySToW() {
+		return WriteGuard{}, false
+	}
+	return WriteGuard(g), true
+}
+
+// Downgrade downgrades the Seek Lock to a Read Lock
+func (g SeekGuard) Downgrade() ReadGuard {
+	g.p.SToR()
+	return ReadGuard(g)
+}
+
+// Release releases the Write Lock
+func (g WriteGuard) Release() {
+	g.p.WUnlock()
+}
+
+// Downgrade downgrades the Write Lock to a Read Lock
+func (g WriteGuard) Downgrade() ReadGuard {
+	g.p.WToR()
+	return ReadGuard(g)
+}
+
+// DowngradeToSeek downgrades the Write Lock to a Seek Lock
+func (g WriteGuard) DowngradeToSeek() SeekGuard {
+	g.p.WToS()
+	return SeekGuard(g)
+}
+
+// Release releases the Atomic Write Lock
+func (g AtomicGuard) Release() {
+	g.p.AUnlock()
+}
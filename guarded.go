@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+package plock
+
+// Guarded holds a value of type T that may only be accessed while the
+// appropriate lock is held. Every helper releases the lock it took when its
+// callback returns, including when the callback panics.
+//
+// The zero value holds the zero value of T, protected by a zero value PMutex
+type Guarded[T any] struct {
+	mu PMutex
+	v  T
+}
+
+// NewGuarded creates a Guarded holding v, protected by a PMutex configured
+// by opts
+func NewGuarded[T any](v T, opts ...Option) *Guarded[T] {
+	g := &Guarded[T]{v: v}
+	g.mu.configure(opts)
+
+	return g
+}
+
+// Unregister removes the lock of g from the registry, if g was created with
+// Named
+func (g *Guarded[T]) Unregister() {
+	g.mu.Unregister()
+}
+
+// Read calls f with a Read Lock held. f must not modify the value
+func (g *Guarded[T]) Read(f func(v *T)) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	f(&g.v)
+}
+
+// Write calls f with a Write Lock held
+func (g *Guarded[T]) Write(f func(v *T)) {
+	g.mu.WLock()
+	defer g.mu.WUnlock()
+
+	f(&g.v)
+}
+
+// Atomic calls f with an Atomic Write Lock held. Other atomic writers may be
+// running concurrently, so f may only modify the value atomically
+func (g *Guarded[T]) Atomic(f func(v *T)) {
+	g.mu.ALock()
+	defer g.mu.AUnlock()
+
+	f(&g.v)
+}
+
+// Seek calls f with a Seek Lock held. f must not modify the value until it
+// has called upgrade, which upgrades the Seek Lock to a Write Lock. Calling
+// upgrade more than once has no further effect
+func (g *Guarded[T]) Seek(f func(v *T, upgrade func())) {
+	upgraded := false
+	g.mu.SLock()
+	defer func() {
+		if upgraded {
+			g.mu.WUnlock()
+		} else {
+			g.mu.SUnlock()
+		}
+	}()
+
+	f(&g.v, func() {
+		if !upgraded {
+			g.mu.SToW()
+			upgraded = true
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package plock
+
+import "sync/atomic"
+
+// The hooks below are called around every acquisition, release and
+// conversion of a PMutex, so that optional features can follow the lock's
+// waiters and holders. Each is a nil check when no options are set
+
+// acquiring is called before the calling goroutine blocks to acquire the lock
+// in mode m. Try methods never block, so they don't call it
+func (p *PMutex) acquiring(m Mode) {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.order != nil {
+		p.opts.order.acquiring(p, m)
+	}
+}
+
+// converting is called before the calling goroutine blocks to upgrade its
+// lock from mode from to mode to
+func (p *PMutex) converting(from, to Mode) {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.order != nil {
+		p.opts.order.converting(p, from, to)
+	}
+}
+
+// waitBegan is called when a blocking acquisition or upgrade first fails, so
+// has to wait
+func (p *PMutex) waitBegan(w *waitStart) {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.registered {
+		atomic.AddInt32(&p.opts.waiting, 1)
+	}
+	if p.opts.owners != nil {
+		p.opts.owners.waitBegan(w)
+	}
+	if p.opts.trace != nil {
+		w.region = p.opts.trace.waitBegan(p, w.mode)
+	}
+}
+
+// waitDraining is called when an acquisition or upgrade that has waited
+// claims the lock, and goes on to wait for readers to leave
+func (p *PMutex) waitDraining() {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.owners != nil {
+		p.opts.owners.waitDraining()
+	}
+}
+
+// waitEnded is called when an acquisition or upgrade that waited either
+// succeeds, before acquired or converted, or is canceled
+func (p *PMutex) waitEnded(w *waitStart) {
+	if p.opts == nil {
+		return
+	}
+	w.region.end()
+	if p.opts.registered {
+		atomic.AddInt32(&p.opts.waiting, -1)
+	}
+	if p.opts.owners != nil {
+		p.opts.owners.waitEnded()
+	}
+}
+
+// acquired is called once the calling goroutine holds the lock in mode m. w
+// follows the waits of a blocking acquisition, and is nil otherwise
+func (p *PMutex) acquired(m Mode, w *waitStart) {
+	if w != nil && w.sampled {
+		recordContention(w)
+	}
+	if p.opts == nil {
+		return
+	}
+	if w != nil && w.waits != 0 {
+		p.waitEnded(w)
+	}
+	if p.opts.owners != nil {
+		p.opts.owners.add(m)
+	}
+	if p.opts.order != nil {
+		p.opts.order.acquired(m)
+	}
+	if p.opts.stats != nil {
+		p.opts.stats.acquired(m, w)
+	}
+	if p.opts.trace != nil {
+		p.opts.trace.acquired(p, m)
+	}
+}
+
+// released is called before the calling goroutine releases the lock held in
+// mode m
+func (p *PMutex) released(m Mode) {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.owners != nil {
+		p.opts.owners.remove(m)
+	}
+	if p.opts.order != nil {
+		p.opts.order.released(m)
+	}
+	if p.opts.stats != nil {
+		p.opts.stats.released(m)
+	}
+	if p.opts.trace != nil {
+		p.opts.trace.released(m)
+	}
+}
+
+// converted is called when the calling goroutine's lock changes from mode
+// from to mode to: after upgrades succeed, and before downgrades happen. w
+// follows the waits of a blocking upgrade, and is nil otherwise
+func (p *PMutex) converted(from, to Mode, w *waitStart) {
+	if w != nil && w.sampled {
+		recordContention(w)
+	}
+	if p.opts == nil {
+		return
+	}
+	if w != nil && w.waits != 0 {
+		p.waitEnded(w)
+	}
+	if p.opts.owners != nil {
+		p.opts.owners.convert(from, to)
+	}
+	if p.opts.order != nil {
+		p.opts.order.converted(from, to)
+	}
+	if p.opts.stats != nil {
+		p.opts.stats.converted(from, to, w)
+	}
+	if p.opts.trace != nil {
+		p.opts.trace.converted(p, from, to)
+	}
+}
@@ -0,0 +1,71 @@
+package plock
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// RegistryHandler returns an http.Handler listing every registered PMutex
+// with its decoded state, holders and waiters, as by PMutex.Info. It serves
+// an HTML page, or a JSON array of LockInfo if the request has format=json
+// in its query or accepts application/json, or the wait-for graph written
+// by WriteWaitForGraph if it has format=dot. It is meant to be mounted at
+// /debug/plock
+func RegistryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locks := Registered()
+		if r.URL.Query().Get("format") == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			WriteWaitForGraph(w, locks...)
+			return
+		}
+
+		infos := make([]LockInfo, len(locks))
+		for i, p := range locks {
+			infos[i] = p.Info()
+		}
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(infos)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		registryPage.Execute(w, infos)
+	})
+}
+
+var registryPage = template.Must(template.New("plock").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>/debug/plock</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+pre { margin: 0; }
+</style>
+</head>
+<body>
+<h1>Registered locks</h1>
+<p>{{len .}} locks. <a href="?format=json">JSON</a> <a href="?format=dot">Wait-for graph</a></p>
+<table>
+<tr><th>Name</th><th>Address</th><th>Mode</th><th>State</th><th>Waiters</th><th>Holders</th><th>Waiting</th></tr>
+{{range .}}<tr>
+<td>{{.Name}}</td>
+<td>{{.Addr}}</td>
+<td>{{.State.Mode}}</td>
+<td>{{.Description}}</td>
+<td>{{.Waiters}}</td>
+<td>{{range .Holders}}<pre>{{.}}</pre>{{end}}</td>
+<td>{{range .Waiting}}<pre>{{.}}</pre>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
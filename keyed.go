@@ -0,0 +1,165 @@
+//go:build go1.18
+// +build go1.18
+
+package plock
+
+import "sync"
+
+type keyedLock struct {
+	p PMutex
+
+	// refs counts the goroutines holding or waiting for p. Guarded by the
+	// KeyedLocks' mu
+	refs int
+}
+
+// KeyedLocks is a set of PMutexes, one per key, created on demand. A key's
+// PMutex exists only while some goroutine holds or waits for it: it is
+// created by the first acquisition, and dropped by the release of the last
+// holder, so memory use is bounded by the number of keys currently in use.
+//
+// Unlike Striped, distinct keys never conflict. Like PMutex, acquiring
+// several keys at once is prone to deadlock unless they are always acquired
+// in the same order.
+//
+// The zero value is an empty KeyedLocks whose PMutexes behave like the zero
+// value PMutex
+type KeyedLocks[K comparable] struct {
+	opts []Option
+
+	mu    sync.Mutex
+	locks map[K]*keyedLock
+}
+
+// NewKeyedLocks creates an empty KeyedLocks whose PMutexes are configured by
+// opts
+func NewKeyedLocks[K comparable](opts ...Option) *KeyedLocks[K] {
+	return &KeyedLocks[K]{opts: opts}
+}
+
+// Len returns the number of keys currently held or waited for
+func (k *KeyedLocks[K]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.locks)
+}
+
+// ref returns the lock for key, creating it if needed, and counts the caller
+// as one of its users
+func (k *KeyedLocks[K]) ref(key K) *keyedLock {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.locks == nil {
+		k.locks = make(map[K]*keyedLock)
+	}
+	l := k.locks[key]
+	if l == nil {
+		l = &keyedLock{}
+		l.p.configure(k.opts)
+		k.locks[key] = l
+	}
+	l.refs++
+
+	return l
+}
+
+// held returns the lock for key, which the caller must hold
+func (k *KeyedLocks[K]) held(key K) *keyedLock {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	l := k.locks[key]
+	if l == nil {
+		panic("plock: key is not locked")
+	}
+
+	return l
+}
+
+// unref stops counting the caller as a user of l, dropping it once it has no
+// users left
+func (k *KeyedLocks[K]) unref(key K, l *keyedLock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, key)
+		l.p.Unregister()
+	}
+}
+
+// RLock acquires a Read Lock on key
+func (k *KeyedLocks[K]) RLock(key K) {
+	k.ref(key).p.RLock()
+}
+
+// RUnlock releases a Read Lock on key
+func (k *KeyedLocks[K]) RUnlock(key K) {
+	l := k.held(key)
+	l.p.RUnlock()
+	k.unref(key, l)
+}
+
+// SLock acquires a Seek Lock on key
+func (k *KeyedLocks[K]) SLock(key K) {
+	k.ref(key).p.SLock()
+}
+
+// SUnlock releases a Seek Lock on key
+func (k *KeyedLocks[K]) SUnlock(key K) {
+	l := k.held(key)
+	l.p.SUnlock()
+	k.unref(key, l)
+}
+
+// WLock acquires a Write Lock on key
+func (k *KeyedLocks[K]) WLock(key K) {
+	k.ref(key).p.WLock()
+}
+
+// WUnlock releases a Write Lock on key
+func (k *KeyedLocks[K]) WUnlock(key K) {
+	l := k.held(key)
+	l.p.WUnlock()
+	k.unref(key, l)
+}
+
+// ALock acquires an Atomic Write Lock on key
+func (k *KeyedLocks[K]) ALock(key K) {
+	k.ref(key).p.ALock()
+}
+
+// AUnlock releases an Atomic Write Lock on key
+func (k *KeyedLocks[K]) AUnlock(key K) {
+	l := k.held(key)
+	l.p.AUnlock()
+	k.unref(key, l)
+}
+
+// RToS upgrades a Read Lock on key to a Seek Lock
+func (k *KeyedLocks[K]) RToS(key K) {
+	k.held(key).p.RToS()
+}
+
+// SToW upgrades a Seek Lock on key to a Write Lock
+func (k *KeyedLocks[K]) SToW(key K) {
+	k.held(key).p.SToW()
+}
+
+// SToR downgrades a Seek Lock on key to a Read Lock
+func (k *KeyedLocks[K]) SToR(key K) {
+	k.held(key).p.SToR()
+}
+
+// WToR downgrades a Write Lock on key to a Read Lock
+func (k *KeyedLocks[K]) WToR(key K) {
+	k.held(key).p.WToR()
+}
+
+// WToS downgrades a Write Lock on key to a Seek Lock
+func (k *KeyedLocks[K]) WToS(key K) {
+	k.held(key).p.WToS()
+}
@@ -0,0 +1,343 @@
+package plock
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// WithLockOrder adds the PMutex to the lock order detector. Whenever a
+// goroutine blocks on one such lock while holding another, the detector
+// records that the held lock is ordered before the wanted one. If that
+// contradicts the order already recorded, in a way that could deadlock, the
+// cycle is reported the first time it is seen, whether or not the goroutines
+// involved actually deadlocked. Read Locks only conflict with Write and
+// Atomic Write Locks, so a cycle of readers is not reported.
+//
+// Upgrading a Read Lock to a Write Lock deadlocks when two readers of the
+// same lock do it at once, so an RToW is reported once a second goroutine
+// upgrades the same lock.
+//
+// Every PMutex is a separate lock to the detector, even if several share a
+// name; WithName only labels it in reports. The detector keeps a global graph
+// of every such lock that has been held while acquiring another and is meant
+// for debugging
+func WithLockOrder() Option {
+	return func(o *options) {
+		o.order = &orderNode{}
+	}
+}
+
+// LockOrderEdge is one observation of a goroutine acquiring a lock while
+// holding another
+type LockOrderEdge struct {
+	Held       string
+	HeldMode   Mode
+	Wanted     string
+	WantedMode Mode
+	// HeldStack is where Held was acquired, and WantedStack where Wanted
+	// was then waited on
+	HeldStack   string
+	WantedStack string
+}
+
+// LockOrderViolation is a cycle of lock order observations. Each edge wants a
+// lock in a mode that conflicts with the mode the next edge holds it in, so
+// goroutines following the edges at the same time can deadlock
+type LockOrderViolation struct {
+	Cycle []LockOrderEdge
+}
+
+// String describes the cycle with the stacks of every edge
+func (v LockOrderViolation) String() string {
+	var b bytes.Buffer
+	b.WriteString("plock: lock order inversion:")
+	for _, e := range v.Cycle {
+		fmt.Fprintf(&b, "\n\n%s (%v) acquired at:\n%s", e.Held, e.HeldMode, e.HeldStack)
+		fmt.Fprintf(&b, "then waited on %s (%v) at:\n%s", e.Wanted, e.WantedMode, e.WantedStack)
+	}
+
+	return b.String()
+}
+
+// SetLockOrderReporter sets the function called with every violation found
+// by the lock order detector. The default writes the violation to stderr. A
+// nil f restores the default
+func SetLockOrderReporter(f func(LockOrderViolation)) {
+	if f == nil {
+		f = reportToStderr
+	}
+
+	order.mu.Lock()
+	order.report = f
+	order.mu.Unlock()
+}
+
+func reportToStderr(v LockOrderViolation) {
+	fmt.Fprintln(os.Stderr, v)
+}
+
+// orderNode is a lock in the lock order graph
+type orderNode struct {
+	name string
+	out  []*orderEdge
+	// upgrades are the edges from the node to itself, one per goroutine
+	// that upgraded it while it could be shared
+	upgrades []*orderEdge
+}
+
+type orderEdge struct {
+	from, to   *orderNode
+	held, want Mode
+	heldPCs    []uintptr
+	wantPCs    []uintptr
+	// g is the goroutine that upgraded, for an edge in upgrades
+	g int64
+}
+
+// orderHeld is a lock held by a goroutine
+type orderHeld struct {
+	node *orderNode
+	mode Mode
+	pcs  []uintptr
+}
+
+// order is the global state of the lock order detector
+var order = struct {
+	mu     sync.Mutex
+	held   map[int64][]orderHeld
+	report func(LockOrderViolation)
+}{
+	held:   map[int64][]orderHeld{},
+	report: reportToStderr,
+}
+
+// upgradeConflicts reports whether two goroutines upgrading the same lock from
+// mode from to mode to at once wait on each other. RToA gives up the Read Lock
+// before waiting for the other readers, so only upgrades keeping a shared
+// mode held while waiting for a conflicting one can deadlock
+func upgradeConflicts(from, to Mode) bool {
+	return !conflicts(from, from) && conflicts(to, from) && to != Atomic
+}
+
+// conflicts reports whether locks in modes a and b can't be held at once
+func conflicts(a, b Mode) bool {
+	switch {
+	case a == Write || b == Write:
+		return true
+	case a == Read || b == Read:
+		return a == Atomic || b == Atomic
+	case a == Seek || b == Seek:
+		return true
+	}
+
+	// both Atomic
+	return false
+}
+
+// acquiring records that the calling goroutine is about to wait for p in
+// mode m while holding its other locks
+func (n *orderNode) acquiring(p *PMutex, m Mode) {
+	n.wait(p, m, Unlocked)
+}
+
+// converting records that the calling goroutine is about to wait to upgrade
+// p from mode from to mode to
+func (n *orderNode) converting(p *PMutex, from, to Mode) {
+	n.wait(p, to, from)
+}
+
+// wait adds an edge to n from every lock the calling goroutine holds. The lock
+// it is upgrading from mode upgrade, if any, is recorded in n.upgrades instead
+func (n *orderNode) wait(p *PMutex, m Mode, upgrade Mode) {
+	g := goid()
+	var pcs []uintptr
+	var found []LockOrderViolation
+
+	order.mu.Lock()
+	if n.name == "" {
+		n.name = p.name()
+	}
+	skipped := upgrade == Unlocked
+	for _, h := range order.held[g] {
+		if h.node == n && !skipped && h.mode == upgrade {
+			skipped = true
+			if !upgradeConflicts(upgrade, m) {
+				continue
+			}
+			if pcs == nil {
+				pcs = callers()
+			}
+			if v, ok := n.upgrade(g, upgrade, m, h.pcs, pcs); ok {
+				found = append(found, v)
+			}
+			continue
+		}
+		if n.hasEdge(h.node, h.mode, m) {
+			continue
+		}
+
+		if pcs == nil {
+			pcs = callers()
+		}
+		e := &orderEdge{from: h.node, to: n, held: h.mode, want: m, heldPCs: h.pcs, wantPCs: pcs}
+		if cycle := e.cycle(); cycle != nil {
+			found = append(found, violation(cycle))
+		}
+		h.node.out = append(h.node.out, e)
+	}
+	report := order.report
+	order.mu.Unlock()
+
+	for _, v := range found {
+		report(v)
+	}
+}
+
+// upgrade records that goroutine g upgrades n from mode held to mode want. The
+// first time another goroutine does the same, both upgrades are returned as a
+// violation
+func (n *orderNode) upgrade(g int64, held, want Mode, heldPCs, wantPCs []uintptr) (LockOrderViolation, bool) {
+	var first *orderEdge
+	for _, e := range n.upgrades {
+		if e.held != held || e.want != want {
+			continue
+		}
+		if e.g == g || first != nil {
+			// already known, or already reported
+			return LockOrderViolation{}, false
+		}
+		first = e
+	}
+
+	e := &orderEdge{from: n, to: n, held: held, want: want, heldPCs: heldPCs, wantPCs: wantPCs, g: g}
+	n.upgrades = append(n.upgrades, e)
+	if first == nil {
+		return LockOrderViolation{}, false
+	}
+
+	return violation([]*orderEdge{first, e}), true
+}
+
+// hasEdge reports whether an edge from from, held in mode held, to n, wanted in
+// mode want, is already known
+func (n *orderNode) hasEdge(from *orderNode, held, want Mode) bool {
+	for _, e := range from.out {
+		if e.to == n && e.held == held && e.want == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cycle returns a cycle of edges that starts with e and could deadlock, or nil
+// if there is none
+func (e *orderEdge) cycle() []*orderEdge {
+	type visit struct {
+		node *orderNode
+		want Mode
+	}
+	seen := map[visit]bool{}
+
+	var search func(path []*orderEdge) []*orderEdge
+	search = func(path []*orderEdge) []*orderEdge {
+		last := path[len(path)-1]
+		if last.to == e.from && conflicts(last.want, e.held) {
+			return path
+		}
+		v := visit{last.to, last.want}
+		if seen[v] {
+			return nil
+		}
+		seen[v] = true
+
+		for _, next := range last.to.out {
+			if conflicts(last.want, next.held) {
+				if cycle := search(append(path, next)); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return search([]*orderEdge{e})
+}
+
+func violation(cycle []*orderEdge) LockOrderViolation {
+	v := LockOrderViolation{Cycle: make([]LockOrderEdge, len(cycle))}
+	for i, e := range cycle {
+		v.Cycle[i] = LockOrderEdge{
+			Held:        e.from.name,
+			HeldMode:    e.held,
+			Wanted:      e.to.name,
+			WantedMode:  e.want,
+			HeldStack:   formatStack(e.heldPCs),
+			WantedStack: formatStack(e.wantPCs),
+		}
+	}
+
+	return v
+}
+
+// acquired adds n to the locks held by the calling goroutine
+func (n *orderNode) acquired(m Mode) {
+	g := goid()
+	pcs := callers()
+
+	order.mu.Lock()
+	order.held[g] = append(order.held[g], orderHeld{node: n, mode: m, pcs: pcs})
+	order.mu.Unlock()
+}
+
+// find returns the goroutine holding n in mode m, preferring g, and the index
+// of its record
+func (n *orderNode) find(g int64, m Mode) (int64, int) {
+	held := order.held[g]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i].node == n && held[i].mode == m {
+			return g, i
+		}
+	}
+
+	// released by another goroutine
+	for other, held := range order.held {
+		for i := range held {
+			if held[i].node == n && held[i].mode == m {
+				return other, i
+			}
+		}
+	}
+
+	return 0, -1
+}
+
+// released removes n from the locks held by the calling goroutine
+func (n *orderNode) released(m Mode) {
+	g := goid()
+
+	order.mu.Lock()
+	if g, i := n.find(g, m); i >= 0 {
+		held := append(order.held[g][:i], order.held[g][i+1:]...)
+		if len(held) == 0 {
+			delete(order.held, g)
+		} else {
+			order.held[g] = held
+		}
+	}
+	order.mu.Unlock()
+}
+
+// converted changes the mode n is held in by the calling goroutine
+func (n *orderNode) converted(from, to Mode) {
+	g := goid()
+
+	order.mu.Lock()
+	if g, i := n.find(g, from); i >= 0 {
+		order.held[g][i].mode = to
+	}
+	order.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+package plock
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// options holds the optional configuration of a PMutex. A PMutex with nil
+// options behaves exactly like the zero value
+type options struct {
+	// word, if set, is the address of the lock word, used in place of the
+	// PMutex's own
+	word unsafe.Pointer
+
+	wait    WaitStrategy
+	ctxWait ContextWaitStrategy
+	waker   WakingStrategy
+	park    *park
+	queue   *waitQueue
+	futex   bool
+	fair    *fairness
+
+	name   string
+	owners *owners
+	order  *orderNode
+	stats  *lockStats
+	trace  *tracer
+
+	// registered is set by Named. waiting counts the goroutines waiting
+	// for a registered PMutex
+	registered bool
+	waiting    int32
+}
+
+// An Option configures a PMutex created by NewPMutex
+type Option func(*options)
+
+// NewPMutex creates an unlocked PMutex configured by opts. With no options,
+// the returned PMutex behaves exactly like the zero value
+func NewPMutex(opts ...Option) *PMutex {
+	p := &PMutex{}
+	p.configure(opts)
+
+	return p
+}
+
+// configure applies opts to p, which must be new, and registers p if opts
+// include Named
+func (p *PMutex) configure(opts []Option) {
+	p.opts = newOptions(opts)
+	if p.opts != nil && p.opts.registered {
+		register(p)
+	}
+}
+
+// newOptions applies opts, returning nil if there are none
+func newOptions(opts []Option) *options {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithWaitStrategy sets the WaitStrategy used between failed attempts to
+// acquire the lock. A nil strategy restores the default, Yield
+func WithWaitStrategy(s WaitStrategy) Option {
+	return func(o *options) {
+		o.wait = s
+		o.ctxWait, _ = s.(ContextWaitStrategy)
+		o.waker, _ = s.(WakingStrategy)
+		o.park, _ = s.(*park)
+	}
+}
+
+// WithParking makes contended goroutines park on a wait list belonging to the
+// PMutex, rather than retrying. A parked goroutine is only woken once the
+// lock could grant what it is waiting for, so waiters consume no CPU while
+// the lock is held. Uncontended acquisitions are unaffected. Parking takes
+// precedence over any WaitStrategy
+func WithParking() Option {
+	return func(o *options) {
+		o.queue = &waitQueue{}
+	}
+}
+
+// WithName names the PMutex in reports and debugging output
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// name returns the name given to p by WithName or, failing that, its address
+func (p *PMutex) name() string {
+	if p.opts != nil && p.opts.name != "" {
+		return p.opts.name
+	}
+
+	return fmt.Sprintf("PMutex %p", p)
+}
@@ -0,0 +1,370 @@
+package plock
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStackDepth is the number of frames recorded for each holder
+const maxStackDepth = 32
+
+// pkgPrefix prefixes the names of this package's functions, which are left
+// out of recorded stacks
+var pkgPrefix = reflect.TypeOf(PMutex{}).PkgPath() + "."
+
+// WithOwnership makes the PMutex record which goroutines hold it, in which
+// mode and where they acquired it, for Holders and the Assert methods, and
+// likewise which goroutines are waiting for it, for Waiting. This costs a
+// stack trace per acquisition and per wait, and is meant for debugging
+func WithOwnership() Option {
+	return func(o *options) {
+		o.owners = &owners{}
+	}
+}
+
+// Holder describes a goroutine holding a PMutex, as recorded by WithOwnership
+type Holder struct {
+	// Goroutine is the ID of the goroutine that took the lock. A lock
+	// released by another goroutine is attributed to the goroutine that
+	// took it
+	Goroutine int64 `json:"goroutine"`
+	Mode      Mode  `json:"mode"`
+	// Since is when the lock entered Mode
+	Since time.Time `json:"since"`
+	// Stack is where the lock entered Mode, formatted like a panic's stack
+	// trace
+	Stack string `json:"stack"`
+}
+
+// String describes the holder like a goroutine in a stack dump
+func (h Holder) String() string {
+	return fmt.Sprintf("goroutine %d holds %v since %v:\n%s", h.Goroutine, h.Mode, h.Since.Format(time.RFC3339Nano), h.Stack)
+}
+
+// Waiter describes a goroutine waiting to acquire or upgrade a PMutex, as
+// recorded by WithOwnership
+type Waiter struct {
+	Goroutine int64 `json:"goroutine"`
+	// Mode is the mode being acquired or upgraded to, and From the mode
+	// being upgraded from, or Unlocked
+	Mode Mode `json:"mode"`
+	From Mode `json:"from"`
+	// Draining is set once the goroutine has claimed the lock and only
+	// waits for readers to leave. New readers then wait for it like for a
+	// holder
+	Draining bool `json:"draining"`
+	// Since is when the goroutine first failed to acquire the lock
+	Since time.Time `json:"since"`
+	// Stack is where the goroutine is waiting, formatted like a panic's
+	// stack trace
+	Stack string `json:"stack"`
+}
+
+// String describes the waiter like a goroutine in a stack dump
+func (w Waiter) String() string {
+	return fmt.Sprintf("goroutine %d waits for %v since %v:\n%s", w.Goroutine, w.Mode, w.Since.Format(time.RFC3339Nano), w.Stack)
+}
+
+// owner is a Holder or Waiter whose stack has not been formatted
+type owner struct {
+	goroutine int64
+	mode      Mode
+	since     time.Time
+	pcs       []uintptr
+
+	// from and draining are only set for waiters
+	from     Mode
+	draining bool
+}
+
+// owners is the list of goroutines holding a PMutex, and of those waiting
+// for it
+type owners struct {
+	mu      sync.Mutex
+	list    []owner
+	waiters []owner
+}
+
+// goid returns the ID of the calling goroutine, which the runtime only
+// reveals in stack traces
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+
+	return id
+}
+
+// callers returns the stack of the calling goroutine
+func callers() []uintptr {
+	pcs := make([]uintptr, maxStackDepth)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+func (o *owners) add(m Mode) {
+	rec := owner{goroutine: goid(), mode: m, since: time.Now(), pcs: callers()}
+
+	o.mu.Lock()
+	o.list = append(o.list, rec)
+	o.mu.Unlock()
+}
+
+// find returns the index of the calling goroutine's record in mode m, or
+// failing that, of the oldest record in mode m. It returns -1 if there is none
+func (o *owners) find(g int64, m Mode) int {
+	found := -1
+	for i := range o.list {
+		if o.list[i].mode != m {
+			continue
+		}
+		if o.list[i].goroutine == g {
+			return i
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+
+	return found
+}
+
+func (o *owners) remove(m Mode) {
+	g := goid()
+
+	o.mu.Lock()
+	if i := o.find(g, m); i >= 0 {
+		o.list = append(o.list[:i], o.list[i+1:]...)
+	}
+	o.mu.Unlock()
+}
+
+func (o *owners) convert(from, to Mode) {
+	g := goid()
+	pcs := callers()
+
+	o.mu.Lock()
+	if i := o.find(g, from); i >= 0 {
+		o.list[i].mode = to
+		o.list[i].since = time.Now()
+		o.list[i].pcs = pcs
+	}
+	o.mu.Unlock()
+}
+
+func (o *owners) waitBegan(w *waitStart) {
+	rec := owner{goroutine: goid(), mode: w.mode, since: time.Now(), pcs: callers(), from: w.from, draining: w.draining}
+
+	o.mu.Lock()
+	o.waiters = append(o.waiters, rec)
+	o.mu.Unlock()
+}
+
+func (o *owners) waitDraining() {
+	g := goid()
+
+	o.mu.Lock()
+	for i := range o.waiters {
+		if o.waiters[i].goroutine == g {
+			o.waiters[i].draining = true
+			break
+		}
+	}
+	o.mu.Unlock()
+}
+
+func (o *owners) waitEnded() {
+	g := goid()
+
+	o.mu.Lock()
+	for i := range o.waiters {
+		if o.waiters[i].goroutine == g {
+			o.waiters = append(o.waiters[:i], o.waiters[i+1:]...)
+			break
+		}
+	}
+	o.mu.Unlock()
+}
+
+// holds reports whether goroutine g holds one of modes
+func (o *owners) holds(g int64, modes ...Mode) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, rec := range o.list {
+		if rec.goroutine != g {
+			continue
+		}
+		for _, m := range modes {
+			if rec.mode == m {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// formatStack formats pcs like a panic's stack trace, starting from the
+// caller of this package
+func formatStack(pcs []uintptr) string {
+	var b bytes.Buffer
+	inside := true
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		inside = inside && strings.HasPrefix(f.Function, pkgPrefix)
+		if !inside && f.Function != "" {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// Holders returns the goroutines currently holding p, oldest first. It
+// returns nil unless p was created with WithOwnership
+func (p *PMutex) Holders() []Holder {
+	if p.opts == nil || p.opts.owners == nil {
+		return nil
+	}
+
+	o := p.opts.owners
+	o.mu.Lock()
+	list := append([]owner(nil), o.list...)
+	o.mu.Unlock()
+
+	holders := make([]Holder, len(list))
+	for i, rec := range list {
+		holders[i] = Holder{
+			Goroutine: rec.goroutine,
+			Mode:      rec.mode,
+			Since:     rec.since,
+			Stack:     formatStack(rec.pcs),
+		}
+	}
+
+	return holders
+}
+
+// Waiting returns the goroutines currently waiting to acquire or upgrade p,
+// longest waiting first. It returns nil unless p was created with
+// WithOwnership
+func (p *PMutex) Waiting() []Waiter {
+	if p.opts == nil || p.opts.owners == nil {
+		return nil
+	}
+
+	o := p.opts.owners
+	o.mu.Lock()
+	list := append([]owner(nil), o.waiters...)
+	o.mu.Unlock()
+
+	waiters := make([]Waiter, len(list))
+	for i, rec := range list {
+		waiters[i] = Waiter{
+			Goroutine: rec.goroutine,
+			Mode:      rec.mode,
+			From:      rec.from,
+			Draining:  rec.draining,
+			Since:     rec.since,
+			Stack:     formatStack(rec.pcs),
+		}
+	}
+
+	return waiters
+}
+
+// assert panics with the current holders if ok is false
+func (p *PMutex) assert(ok bool, format string, args ...interface{}) {
+	if ok {
+		return
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "plock: "+format, args...)
+	if holders := p.Holders(); holders != nil {
+		fmt.Fprintf(&b, "; %d holders", len(holders))
+		for _, h := range holders {
+			b.WriteString("\n\n")
+			b.WriteString(h.String())
+		}
+	} else {
+		fmt.Fprintf(&b, "; lock is %v", p.State())
+	}
+
+	panic(b.String())
+}
+
+// assertHolds panics unless the calling goroutine holds p in one of modes.
+// Without ownership tracking, it can only check that someone does
+func (p *PMutex) assertHolds(name string, modes ...Mode) {
+	if p.opts == nil || p.opts.owners == nil {
+		s := p.State()
+		ok := false
+		for _, m := range modes {
+			switch m {
+			case Read:
+				ok = ok || s.Readers != 0
+			case Seek:
+				ok = ok || s.Seeker
+			case Write:
+				ok = ok || s.Mode == Write
+			case Atomic:
+				ok = ok || (s.Writers != 0 && !s.Seeker)
+			}
+		}
+		p.assert(ok, "%s failed: no %v Lock is held", name, modes[0])
+		return
+	}
+
+	g := goid()
+	p.assert(p.opts.owners.holds(g, modes...), "%s failed: goroutine %d holds no %v Lock", name, g, modes[0])
+}
+
+// AssertRLocked panics unless the calling goroutine holds p in a mode that
+// allows reading: Read, Seek or Write. Without WithOwnership, it can only
+// check that some goroutine does
+func (p *PMutex) AssertRLocked() {
+	p.assertHolds("AssertRLocked", Read, Seek, Write)
+}
+
+// AssertSLocked panics unless the calling goroutine holds p's Seek Lock.
+// Without WithOwnership, it can only check that some goroutine does
+func (p *PMutex) AssertSLocked() {
+	p.assertHolds("AssertSLocked", Seek)
+}
+
+// AssertWLocked panics unless the calling goroutine holds p's Write Lock.
+// Without WithOwnership, it can only check that some goroutine does
+func (p *PMutex) AssertWLocked() {
+	p.assertHolds("AssertWLocked", Write)
+}
+
+// AssertALocked panics unless the calling goroutine holds an Atomic Write
+// Lock on p. Without WithOwnership, it can only check that some goroutine
+// does
+func (p *PMutex) AssertALocked() {
+	p.assertHolds("AssertALocked", Atomic)
+}
+
+// AssertNotHeld panics if the calling goroutine holds p in any mode. It only
+// checks anything if p was created with WithOwnership
+func (p *PMutex) AssertNotHeld() {
+	if p.opts == nil || p.opts.owners == nil {
+		return
+	}
+
+	g := goid()
+	p.assert(!p.opts.owners.holds(g, Read, Seek, Write, Atomic), "AssertNotHeld failed: goroutine %d holds the lock", g)
+}
@@ -0,0 +1,111 @@
+package plock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// want describes the lock state a waiter needs before its next attempt to
+// acquire can succeed
+type want uint8
+
+const (
+	wantNoWriter want = iota
+	wantNoSeeker
+	wantNoSeekerOrWriter
+	wantSoleReader
+	wantNoReaders
+	wantNoQueuedWriters
+)
+
+// ready reports whether w is currently satisfied
+func (p *PMutex) ready(w want) bool {
+	if w == wantNoQueuedWriters {
+		return atomic.LoadInt32(&p.opts.fair.writers) == 0
+	}
+	return p.grantable(w)
+}
+
+type waiter struct {
+	want want
+	ch   chan struct{}
+}
+
+// waitQueue is the list of goroutines parked on a PMutex
+type waitQueue struct {
+	// n is the number of parked waiters. It is read without holding mu so
+	// that releasing an uncontended lock never takes mu
+	n int32
+
+	mu      sync.Mutex
+	waiters []*waiter
+}
+
+// park blocks until the lock looks like it could grant w, or ctx is done.
+// The caller is expected to retry its acquisition after park returns
+func (q *waitQueue) park(ctx context.Context, p *PMutex, w want) {
+	wt := &waiter{want: w, ch: make(chan struct{})}
+
+	q.mu.Lock()
+	q.waiters = append(q.waiters, wt)
+	atomic.AddInt32(&q.n, 1)
+
+	// the lock may have been released between the failed attempt and
+	// registering above, in which case nobody is going to wake us up
+	if p.ready(w) {
+		q.remove(wt)
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	select {
+	case <-wt.ch:
+	case <-done:
+		q.mu.Lock()
+		q.remove(wt)
+		q.mu.Unlock()
+	}
+}
+
+// remove deletes wt from the list, if it is still there. mu must be held
+func (q *waitQueue) remove(wt *waiter) {
+	for i := range q.waiters {
+		if q.waiters[i] == wt {
+			copy(q.waiters[i:], q.waiters[i+1:])
+			q.waiters[len(q.waiters)-1] = nil
+			q.waiters = q.waiters[:len(q.waiters)-1]
+			atomic.AddInt32(&q.n, -1)
+			return
+		}
+	}
+}
+
+// wake wakes every parked waiter whose wanted state the lock is now in
+func (q *waitQueue) wake(p *PMutex) {
+	if atomic.LoadInt32(&q.n) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	kept := q.waiters[:0]
+	for _, wt := range q.waiters {
+		if p.ready(wt.want) {
+			close(wt.ch)
+			atomic.AddInt32(&q.n, -1)
+			continue
+		}
+		kept = append(kept, wt)
+	}
+	for i := len(kept); i < len(q.waiters); i++ {
+		q.waiters[i] = nil
+	}
+	q.waiters = kept
+	q.mu.Unlock()
+}
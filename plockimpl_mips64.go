@@ -1,9 +1,7 @@
 package plock
 
 import (
-	"fmt"
-	"runtime"
-
+	"context"
 	"sync/atomic"
 )
 
@@ -15,8 +13,29 @@ import (
 // that have the obligation to interact with protected data atomically; and
 // Seek Lock, which is an exclusive reader that can quickly upgrade its lock
 // to Write
+//
+// The zero value is an unlocked PMutex that yields to the scheduler while
+// waiting; use NewPMutex to configure anything else
 type PMutex struct {
 	lock uint64
+	opts *options
+}
+
+// addr returns the address of the lock word, which lives outside of p when
+// it is shared with other processes
+func (p *PMutex) addr() *uint64 {
+	if p.opts != nil && p.opts.word != nil {
+		return (*uint64)(p.opts.word)
+	}
+	return &p.lock
+}
+
+// fastLock sets the bits of a Write or Atomic Write Lock in an unlocked
+// PMutex without options. Such a PMutex needs none of the fairness, hook or
+// waiting machinery, so the blocking methods try this first and fall back to
+// the slow path only when it fails
+func (p *PMutex) fastLock(set uint64) bool {
+	return p.opts == nil && atomic.CompareAndSwapUint64(&p.lock, 0, set)
 }
 
 const (
@@ -24,52 +43,66 @@ const (
 	rightShiftVal = 34
 )
 
-// String returns a string representing the internal lock state
-// The string built by this function reflects a single moment in time,
-// _NOT_ the current moment; i.e. Do NOT rely on this for anything other
-// than debugging
-func (p *PMutex) String() string {
-	v := atomic.LoadUint64(&p.lock)
-	addr := fmt.Sprintf("Addr: %d;", &p.lock)
-	if v == 0 {
-		return addr + " U"
-	}
-
-	hasWriter := v&plock64WLAny != 0
-	hasSeeker := v&plock64SLAny != 0
-	hasReader := v&plock64RLAny != 0
-
-	numReaders := (v << leftShiftVal) >> rightShiftVal
-	if hasWriter && !hasSeeker && !hasReader {
-		numWriters := v >> rightShiftVal
-		return fmt.Sprintf("%s A; writers: %d", addr, numWriters)
-	}
+// State returns a decoded snapshot of the lock word. Like String, the
+// snapshot reflects a single moment in time, _NOT_ the current moment
+func (p *PMutex) State() State {
+	return decode(atomic.LoadUint64(p.addr()))
+}
 
-	if hasReader && !hasSeeker && !hasWriter {
-		if numReaders == 1 {
-			return addr + " R; readers: self only"
-		}
+// decode decodes the lock word v
+func decode(v uint64) State {
+	return newState(
+		uintptr(v),
+		int((v<<leftShiftVal)>>rightShiftVal),
+		int(v>>rightShiftVal),
+		v&plock64SLAny != 0,
+	)
+}
 
-		return fmt.Sprintf("%s R; readers: %d", addr, numReaders-1)
+// holds reports whether the lock word v can include a lock of kind h. Failed
+// attempts briefly add bits of their own, so this only rules out locks whose
+// bits are missing altogether
+func holds(v uint64, h hold) bool {
+	readers := (v & plock64RLAny) / plock64RL1
+	seekers := (v & plock64SLAny) / plock64SL1
+	writers := v & plock64WLAny
+
+	switch h {
+	case holdRead:
+		// every seeker, and so every writer, also counts as a reader
+		return readers > seekers
+	case holdSeek:
+		return seekers != 0 && readers != 0
+	case holdWrite:
+		return writers != 0 && seekers != 0 && readers != 0
+	case holdAtomic:
+		return writers != 0
 	}
 
-	s := addr + " "
-	if hasReader {
-		s += "R"
-	}
-	if hasSeeker {
-		s += "+S"
-	}
-	if hasWriter {
-		if numReaders == 1 {
-			s += "+W; readers: self only"
-		} else {
-			s += fmt.Sprintf("+W; waiting for readers: %d", numReaders-1)
+	return false
+}
 
+// checkedSub subtracts val from the lock word like subUint64, after checking
+// that the word holds the lock that op releases. It panics instead if not.
+// Only builds with the plock_debug tag use it
+func (p *PMutex) checkedSub(op string, h hold, val uint64) {
+	for {
+		v := atomic.LoadUint64(p.addr())
+		if !holds(v, h) {
+			panic(misuse(op, h, decode(v)))
+		}
+		if atomic.CompareAndSwapUint64(p.addr(), v, v-val) {
+			return
 		}
 	}
+}
 
-	return s
+// mustHold panics if the lock word shows that the lock op converts isn't
+// held. Only builds with the plock_debug tag use it
+func (p *PMutex) mustHold(op string, h hold) {
+	if v := atomic.LoadUint64(p.addr()); !holds(v, h) {
+		panic(misuse(op, h, decode(v)))
+	}
 }
 
 //go:nosplit
@@ -79,262 +112,752 @@ func (p *PMutex) tryRLock() bool {
 
 	// Since all writes to this value are atomic, load is unnecessary,
 	// but it makes the race detector happy
-	if (atomic.LoadUint64(&p.lock) & maskR) != 0 {
+	if (atomic.LoadUint64(p.addr()) & maskR) != 0 {
 		return false
 	}
-	if xadd64(&p.lock, setR)&maskR == 0 {
+	if xadd64(p.addr(), setR)&maskR == 0 {
 		return true
 	}
-	_ = subUint64(&p.lock, setR)
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
 
 	return false
 }
 
+// TryRLock attempts to acquire a Read Lock without blocking. It returns true
+// if the lock was acquired
+func (p *PMutex) TryRLock() bool {
+	if !p.tryRLock() {
+		return false
+	}
+	p.acquired(Read, nil)
+
+	return true
+}
+
 // RLock acquires a Read lock. This method will block until the lock is acquired,
-// yielding the goroutine to the scheduler after every failure to acquire
+// waiting according to the mutex's WaitStrategy after every failure to acquire
 func (p *PMutex) RLock() {
-	for {
+	if p.opts == nil && p.tryRLock() {
+		return
+	}
+	_ = p.rlock(nil)
+}
+
+// RLockContext acquires a Read Lock like RLock, but gives up and returns
+// ctx.Err() if ctx is done before the lock could be acquired
+func (p *PMutex) RLockContext(ctx context.Context) error {
+	if p.opts == nil && p.tryRLock() {
+		return nil
+	}
+	return p.rlock(ctx)
+}
+
+func (p *PMutex) rlock(ctx context.Context) error {
+	p.acquiring(Read)
+	if err := p.enter(ctx, roleReader); err != nil {
+		return err
+	}
+	defer p.leave(roleReader)
+
+	since := waitStart{mode: Read}
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.tryRLock() {
-			break
+			p.acquired(Read, &since)
+			return nil
+		}
+		if err := since.canceled(p, ctx); err != nil {
+			return err
 		}
 
-		runtime.Gosched()
+		since.wait(p)
+		p.wait(ctx, wantNoWriter, i, gen)
 	}
 }
 
 // RUnlock releases an existing Read Lock
 func (p *PMutex) RUnlock() {
 	const val = plock64RL1
-	_ = subUint64(&p.lock, val)
+	p.released(Read)
+	if debug {
+		p.checkedSub("RUnlock", holdRead, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
 }
 
+// tryRToA trades the caller's Read Lock for an Atomic Write Lock, provided
+// that no Seek Lock is held. Other readers may still be present when this
+// returns true
 func (p *PMutex) tryRToA() bool {
-	plr := atomic.LoadUint64(&p.lock) & plock64SLAny
-	if plr == 0 {
-		plr = xadd64(&p.lock, plock64WL1-plock64RL1)
-		for {
-			if plr&plock64SLAny != 0 {
-				_ = subUint64(&p.lock, plock64WL1-plock64RL1)
-				break
-			}
-
-			plr &= plock64RLAny
-			if plr != 0 {
-				break
-			}
-			plr = atomic.LoadUint64(&p.lock)
-		}
+	const setR = plock64WL1 - plock64RL1
+	const maskR = plock64SLAny
+
+	if atomic.LoadUint64(p.addr())&maskR != 0 {
+		return false
+	}
+	if xadd64(p.addr(), setR)&maskR == 0 {
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
+
+	return false
+}
+
+// TryRToA attempts to upgrade an existing Read Lock to an Atomic Write Lock
+// without blocking. It returns true if the upgrade succeeded; otherwise the
+// Read Lock is still held
+func (p *PMutex) TryRToA() bool {
+	const setR = plock64WL1 - plock64RL1
+
+	if debug {
+		p.mustHold("TryRToA", holdRead)
 	}
 
-	return plr != 0
+	if !p.tryRToA() {
+		return false
+	}
+	if atomic.LoadUint64(p.addr())&plock64RLAny == 0 {
+		p.converted(Read, Atomic, nil)
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
+
+	return false
 }
 
 // RToA upgrades an existing Read Lock to an Atomic Write Lock
 func (p *PMutex) RToA() {
-	for {
+	_ = p.rToA(nil)
+}
+
+// RToAContext upgrades an existing Read Lock like RToA, but gives up and
+// returns ctx.Err() if ctx is done first. On failure, the Read Lock is still
+// held
+func (p *PMutex) RToAContext(ctx context.Context) error {
+	return p.rToA(ctx)
+}
+
+func (p *PMutex) rToA(ctx context.Context) error {
+	const setR = plock64WL1 - plock64RL1
+
+	if debug {
+		p.mustHold("RToA", holdRead)
+	}
+	p.converting(Read, Atomic)
+
+	since := waitStart{mode: Atomic, from: Read}
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.tryRToA() {
 			break
 		}
+		if err := since.canceled(p, ctx); err != nil {
+			return err
+		}
 
-		runtime.Gosched()
+		since.wait(p)
+		p.wait(ctx, wantNoSeeker, i, gen)
+	}
+
+	// wait for the remaining readers to leave
+	since.drain(p)
+	for i := 0; ; i++ {
+		gen := p.generation()
+		if atomic.LoadUint64(p.addr())&plock64RLAny == 0 {
+			p.converted(Read, Atomic, &since)
+			return nil
+		}
+		if err := since.canceled(p, ctx); err != nil {
+			_ = subUint64(p.addr(), setR)
+			p.wake()
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantNoReaders, i, gen)
 	}
 }
 
+// tryRToW adds the Write and Seek bits to the caller's Read Lock, provided
+// that no other Write or Seek Lock is held. Other readers may still be
+// present when this returns true
 func (p *PMutex) tryRToW() bool {
 	const setR = plock64WL1 | plock64SL1
 	const maskR = plock64WLAny | plock64SLAny
-	var plr uint64
-	for {
-		plr = xadd64(&p.lock, setR)
-		if plr&maskR != 0 {
-			if xadd64(&p.lock, (^setR)+1) != 0 {
-				break
-			}
-			continue
-		}
 
-		for plr != 0 {
-			plr = atomic.LoadUint64(&p.lock) - (plock64WL1 | plock64SL1 | plock64RL1) //nolint:megacheck
-			break
-		}
+	if atomic.LoadUint64(p.addr())&maskR != 0 {
+		return false
+	}
+	if xadd64(p.addr(), setR)&maskR == 0 {
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
+
+	return false
+}
+
+// TryRToW attempts to upgrade an existing Read Lock to a Write Lock without
+// blocking. It returns true if the upgrade succeeded; otherwise the Read Lock
+// is still held
+func (p *PMutex) TryRToW() bool {
+	const setR = plock64WL1 | plock64SL1
+
+	if debug {
+		p.mustHold("TryRToW", holdRead)
+	}
+
+	if !p.tryRToW() {
+		return false
+	}
+	if atomic.LoadUint64(p.addr())&plock64RLAny == plock64RL1 {
+		p.converted(Read, Write, nil)
+		return true
 	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
 
-	return plr == 0
+	return false
 }
 
 // RToW upgrades an existing Read Lock to a Write Lock.
+// Two readers attempting this simultaneously will deadlock, as each waits for
+// the other to release its Read Lock; use RToS or TryRToW when that is possible
 func (p *PMutex) RToW() {
-	for {
+	_ = p.rToW(nil)
+}
+
+// RToWContext upgrades an existing Read Lock like RToW, but gives up and
+// returns ctx.Err() if ctx is done first. On failure, the Read Lock is still
+// held
+func (p *PMutex) RToWContext(ctx context.Context) error {
+	return p.rToW(ctx)
+}
+
+func (p *PMutex) rToW(ctx context.Context) error {
+	const setR = plock64WL1 | plock64SL1
+
+	if debug {
+		p.mustHold("RToW", holdRead)
+	}
+	p.converting(Read, Write)
+
+	since := waitStart{mode: Write, from: Read}
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.tryRToW() {
 			break
 		}
+		if err := since.canceled(p, ctx); err != nil {
+			return err
+		}
 
-		runtime.Gosched()
+		since.wait(p)
+		p.wait(ctx, wantNoSeekerOrWriter, i, gen)
+	}
+
+	// wait for readers to leave
+	since.drain(p)
+	for i := 0; ; i++ {
+		gen := p.generation()
+		if atomic.LoadUint64(p.addr())&plock64RLAny == plock64RL1 {
+			p.converted(Read, Write, &since)
+			return nil
+		}
+		if err := since.canceled(p, ctx); err != nil {
+			_ = subUint64(p.addr(), setR)
+			p.wake()
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantSoleReader, i, gen)
 	}
 }
 
 func (p *PMutex) tryRToS() bool {
-	plr := atomic.LoadUint64(&p.lock)
+	plr := atomic.LoadUint64(p.addr())
 	if plr&(plock64WLAny|plock64SLAny) == 0 {
-		plr = xadd64(&p.lock, plock64SL1) & (plock64WLAny | plock64SLAny)
+		plr = xadd64(p.addr(), plock64SL1) & (plock64WLAny | plock64SLAny)
 		if plr != 0 {
-			_ = subUint64(&p.lock, plock64SL1)
+			_ = subUint64(p.addr(), plock64SL1)
+			p.wakeParked()
 		}
 
 	}
 
-	return plr == 0
+	return plr&(plock64WLAny|plock64SLAny) == 0
+}
+
+// TryRToS attempts to upgrade an existing Read Lock to a Seek Lock without
+// blocking. It returns true if the upgrade succeeded; otherwise the Read Lock
+// is still held
+func (p *PMutex) TryRToS() bool {
+	if debug {
+		p.mustHold("TryRToS", holdRead)
+	}
+
+	if !p.tryRToS() {
+		return false
+	}
+	p.converted(Read, Seek, nil)
+
+	return true
 }
 
 // RToS upgrades an existing Read Lock to a Seek Lock
 func (p *PMutex) RToS() {
-	for {
+	_ = p.rToS(nil)
+}
+
+// RToSContext upgrades an existing Read Lock like RToS, but gives up and
+// returns ctx.Err() if ctx is done first. On failure, the Read Lock is still
+// held
+func (p *PMutex) RToSContext(ctx context.Context) error {
+	return p.rToS(ctx)
+}
+
+func (p *PMutex) rToS(ctx context.Context) error {
+	if debug {
+		p.mustHold("RToS", holdRead)
+	}
+	p.converting(Read, Seek)
+
+	since := waitStart{mode: Seek, from: Read}
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.tryRToS() {
-			break
+			p.converted(Read, Seek, &since)
+			return nil
+		}
+		if err := since.canceled(p, ctx); err != nil {
+			return err
 		}
 
-		runtime.Gosched()
+		since.wait(p)
+		p.wait(ctx, wantNoSeekerOrWriter, i, gen)
 	}
 }
 
+// tryWLock takes the Write, Seek and Read bits, provided that no other Write
+// or Seek Lock is held. Readers may still be present when this returns true
+//
 //go:nosplit
 func (p *PMutex) tryWLock() bool {
 	const setR = plock64WL1 | plock64SL1 | plock64RL1
 	const maskR = plock64WLAny | plock64SLAny
 
-	if xadd64(&p.lock, setR)&maskR == 0 {
+	if xadd64(p.addr(), setR)&maskR == 0 {
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
+
+	return false
+}
+
+// TryWLock attempts to acquire a Write Lock without blocking. It returns true
+// if the lock was acquired. Unlike WLock, this never waits for readers to
+// leave: if any are present, nothing is taken and false is returned
+func (p *PMutex) TryWLock() bool {
+	const setR = plock64WL1 | plock64SL1 | plock64RL1
+	const maskR = plock64WLAny | plock64SLAny | plock64RLAny
+
+	if atomic.LoadUint64(p.addr())&maskR != 0 {
+		return false
+	}
+	if xadd64(p.addr(), setR)&maskR == 0 {
+		p.acquired(Write, nil)
 		return true
 	}
-	_ = subUint64(&p.lock, setR)
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
 
 	return false
 }
 
 // WLock acquires a Write Lock, blocking until all current readers unlock
 func (p *PMutex) WLock() {
+	if p.fastLock(plock64WL1 | plock64SL1 | plock64RL1) {
+		return
+	}
+	_ = p.wlock(nil)
+}
+
+// WLockContext acquires a Write Lock like WLock, but gives up and returns
+// ctx.Err() if ctx is done before the lock could be acquired. If that happens
+// while waiting for readers to leave, the partially acquired lock is released
+func (p *PMutex) WLockContext(ctx context.Context) error {
+	if p.fastLock(plock64WL1 | plock64SL1 | plock64RL1) {
+		return nil
+	}
+	return p.wlock(ctx)
+}
+
+func (p *PMutex) wlock(ctx context.Context) error {
 	const setR = plock64WL1 | plock64SL1 | plock64RL1
 
+	p.acquiring(Write)
+	if err := p.enter(ctx, roleWriter); err != nil {
+		return err
+	}
+	defer p.leave(roleWriter)
+
+	since := waitStart{mode: Write}
+
 	// acquire lock
-	for {
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.tryWLock() {
 			break
 		}
-		runtime.Gosched()
+		if err := since.canceled(p, ctx); err != nil {
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantNoSeekerOrWriter, i, gen)
 	}
 
 	// wait for readers to leave
-	for {
-		if (atomic.LoadUint64(&p.lock) - setR) == 0 {
-			break
+	since.drain(p)
+	for i := 0; ; i++ {
+		gen := p.generation()
+		if atomic.LoadUint64(p.addr())&plock64RLAny == plock64RL1 {
+			p.acquired(Write, &since)
+			return nil
+		}
+		if err := since.canceled(p, ctx); err != nil {
+			_ = subUint64(p.addr(), setR)
+			p.wake()
+			return err
 		}
 		// yield here in the this half acquired state;
 		// this allows readers the opportunity to finish up, and prevents
 		// new readers/writers from entering
-		runtime.Gosched()
+		since.wait(p)
+		p.wait(ctx, wantSoleReader, i, gen)
 	}
 }
 
 // WUnlock releases an existing Write Lock.
 func (p *PMutex) WUnlock() {
 	const val = plock64WL1 | plock64SL1 | plock64RL1
-	_ = subUint64(&p.lock, val)
+	p.released(Write)
+	if debug {
+		p.checkedSub("WUnlock", holdWrite, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
 }
 
 // WToR downgrades an existing Write Lock to a Read Lock
 func (p *PMutex) WToR() {
 	const val = plock64WL1 | plock64SL1
-	_ = subUint64(&p.lock, val)
+	p.converted(Write, Read, nil)
+	if debug {
+		p.checkedSub("WToR", holdWrite, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
 }
 
-// WToR downgrades an existing Write Lock to a Seek Lock
+// WToS downgrades an existing Write Lock to a Seek Lock
 func (p *PMutex) WToS() {
 	const val = plock64WL1
-	_ = subUint64(&p.lock, val)
+	p.converted(Write, Seek, nil)
+	if debug {
+		p.checkedSub("WToS", holdWrite, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
 }
 
 //go:nosplit
 func (p *PMutex) trySLock() bool {
 	const setR = plock64SL1 | plock64RL1
 	const maskR = plock64WLAny | plock64SLAny
-	if atomic.LoadUint64(&p.lock)&maskR == 0 {
-		if xadd64(&p.lock, setR) == 0 {
+	if atomic.LoadUint64(p.addr())&maskR == 0 {
+		if xadd64(p.addr(), setR)&maskR == 0 {
 			return true
 		}
-		_ = subUint64(&p.lock, setR)
+		_ = subUint64(p.addr(), setR)
+		p.wakeParked()
 	}
 	return false
 }
 
+// TrySLock attempts to acquire a Seek Lock without blocking. It returns true
+// if the lock was acquired
+func (p *PMutex) TrySLock() bool {
+	if !p.trySLock() {
+		return false
+	}
+	p.acquired(Seek, nil)
+
+	return true
+}
+
 // SLock acquires a Seek Lock. This state allows for an exclusive reader,
 // which has the ability to quickly upgrade to a Write Lock if needed
 func (p *PMutex) SLock() {
-	for {
+	if p.opts == nil && p.trySLock() {
+		return
+	}
+	_ = p.slock(nil)
+}
+
+// SLockContext acquires a Seek Lock like SLock, but gives up and returns
+// ctx.Err() if ctx is done before the lock could be acquired
+func (p *PMutex) SLockContext(ctx context.Context) error {
+	if p.opts == nil && p.trySLock() {
+		return nil
+	}
+	return p.slock(ctx)
+}
+
+func (p *PMutex) slock(ctx context.Context) error {
+	p.acquiring(Seek)
+	if err := p.enter(ctx, roleReader); err != nil {
+		return err
+	}
+	defer p.leave(roleReader)
+
+	since := waitStart{mode: Seek}
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.trySLock() {
-			break
+			p.acquired(Seek, &since)
+			return nil
 		}
-		runtime.Gosched()
+		if err := since.canceled(p, ctx); err != nil {
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantNoSeekerOrWriter, i, gen)
 	}
 }
 
 // SUnlock releases an existing Seek Lock
 func (p *PMutex) SUnlock() {
 	const val = plock64SL1 + plock64RL1
-	_ = subUint64(&p.lock, val)
+	p.released(Seek)
+	if debug {
+		p.checkedSub("SUnlock", holdSeek, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
 }
 
 // SToR downgrades an existing Seek Lock to a Read Lock
 func (p *PMutex) SToR() {
 	const val = plock64SL1
-	_ = subUint64(&p.lock, val)
+	p.converted(Seek, Read, nil)
+	if debug {
+		p.checkedSub("SToR", holdSeek, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
+}
+
+// TrySToW attempts to upgrade an existing Seek Lock to a Write Lock without
+// blocking. It returns true if the upgrade succeeded, which is only possible
+// when no other readers are present; otherwise the Seek Lock is still held
+func (p *PMutex) TrySToW() bool {
+	const setR = plock64WL1
+
+	if debug {
+		p.mustHold("TrySToW", holdSeek)
+	}
+
+	if xadd64(p.addr(), setR)&plock64RLAny == plock64RL1 {
+		p.converted(Seek, Write, nil)
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
+
+	return false
 }
 
-// SToR upgrades an existing Seek Lock to a Write Lock
+// SToW upgrades an existing Seek Lock to a Write Lock. Since a Seek Lock
+// excludes other writers, this only has to wait for readers to leave
 func (p *PMutex) SToW() {
-	t := xadd64(&p.lock, plock64WL1)
-	for {
-		if t&plock64RLAny != plock64RL1 {
-			t = atomic.LoadUint64(&p.lock)
+	_ = p.sToW(nil)
+}
+
+// SToWContext upgrades an existing Seek Lock like SToW, but gives up and
+// returns ctx.Err() if ctx is done before the other readers have left. On
+// failure, the Seek Lock is still held
+func (p *PMutex) SToWContext(ctx context.Context) error {
+	return p.sToW(ctx)
+}
+
+func (p *PMutex) sToW(ctx context.Context) error {
+	const setR = plock64WL1
+
+	if debug {
+		p.mustHold("SToW", holdSeek)
+	}
+	p.converting(Seek, Write)
+
+	_ = xadd64(p.addr(), setR)
+
+	since := waitStart{mode: Write, from: Seek}
+
+	// wait for readers to leave
+	since.drain(p)
+	for i := 0; ; i++ {
+		gen := p.generation()
+		if atomic.LoadUint64(p.addr())&plock64RLAny == plock64RL1 {
+			p.converted(Seek, Write, &since)
+			return nil
 		}
+		if err := since.canceled(p, ctx); err != nil {
+			_ = subUint64(p.addr(), setR)
+			p.wake()
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantSoleReader, i, gen)
 	}
 }
 
+// tryALock takes the Write bit, provided that no Seek Lock (and therefore no
+// Write Lock) is held. Readers may still be present when this returns true
+//
 //go:nosplit
 func (p *PMutex) tryALock() bool {
 	const setR = plock64WL1
 	const maskR = plock64SLAny
 
-	plr := atomic.LoadUint64(&p.lock) & maskR
-	if plr == 0 {
-		plr = xadd64(&p.lock, setR)
-		for {
-			if plr&maskR != 0 {
-				_ = subUint64(&p.lock, setR)
-				break
-			}
-			plr &= plock64RLAny
-			if plr == 0 {
-				break
-			}
-			plr = atomic.LoadUint64(&p.lock)
-		}
+	if atomic.LoadUint64(p.addr())&maskR != 0 {
+		return false
+	}
+	if xadd64(p.addr(), setR)&maskR == 0 {
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
+
+	return false
+}
+
+// TryALock attempts to acquire an Atomic Write Lock without blocking. It
+// returns true if the lock was acquired. Unlike ALock, this never waits for
+// readers to leave: if any are present, nothing is taken and false is returned
+func (p *PMutex) TryALock() bool {
+	const setR = plock64WL1
+
+	if !p.tryALock() {
+		return false
 	}
+	if atomic.LoadUint64(p.addr())&plock64RLAny == 0 {
+		p.acquired(Atomic, nil)
+		return true
+	}
+	_ = subUint64(p.addr(), setR)
+	p.wakeParked()
 
-	return plr == 0
+	return false
 }
 
 // ALock acquires an Atomic Write Lock. Atomic Write allows for multiple writers,
 // however all writers must access the shared data atomically (ex: sync/atomic.*)
 func (p *PMutex) ALock() {
-	for {
+	if p.fastLock(plock64WL1) {
+		return
+	}
+	_ = p.alock(nil)
+}
+
+// ALockContext acquires an Atomic Write Lock like ALock, but gives up and
+// returns ctx.Err() if ctx is done before the lock could be acquired. If that
+// happens while waiting for readers to leave, the partially acquired lock is
+// released
+func (p *PMutex) ALockContext(ctx context.Context) error {
+	if p.fastLock(plock64WL1) {
+		return nil
+	}
+	return p.alock(ctx)
+}
+
+func (p *PMutex) alock(ctx context.Context) error {
+	const setR = plock64WL1
+
+	p.acquiring(Atomic)
+	if err := p.enter(ctx, roleWriter); err != nil {
+		return err
+	}
+	defer p.leave(roleWriter)
+
+	since := waitStart{mode: Atomic}
+	for i := 0; ; i++ {
+		gen := p.generation()
 		if p.tryALock() {
 			break
 		}
-		runtime.Gosched()
+		if err := since.canceled(p, ctx); err != nil {
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantNoSeeker, i, gen)
+	}
+
+	// wait for readers to leave
+	since.drain(p)
+	for i := 0; ; i++ {
+		gen := p.generation()
+		if atomic.LoadUint64(p.addr())&plock64RLAny == 0 {
+			p.acquired(Atomic, &since)
+			return nil
+		}
+		if err := since.canceled(p, ctx); err != nil {
+			_ = subUint64(p.addr(), setR)
+			p.wake()
+			return err
+		}
+		since.wait(p)
+		p.wait(ctx, wantNoReaders, i, gen)
 	}
 }
 
+// grantable reports whether the lock currently looks like it would grant what
+// a waiter wants
+func (p *PMutex) grantable(w want) bool {
+	v := atomic.LoadUint64(p.addr())
+
+	switch w {
+	case wantNoWriter:
+		return v&plock64WLAny == 0
+	case wantNoSeeker:
+		return v&plock64SLAny == 0
+	case wantNoSeekerOrWriter:
+		return v&(plock64WLAny|plock64SLAny) == 0
+	case wantSoleReader:
+		return v&plock64RLAny == plock64RL1
+	case wantNoReaders:
+		return v&plock64RLAny == 0
+	}
+
+	return true
+}
+
 // AUnlock releases an Atomic Write Lock
 func (p *PMutex) AUnlock() {
 	const val = plock64WL1
-	_ = subUint64(&p.lock, val)
+	p.released(Atomic)
+	if debug {
+		p.checkedSub("AUnlock", holdAtomic, val)
+	} else {
+		_ = subUint64(p.addr(), val)
+	}
+	p.wake()
 }
@@ -0,0 +1,131 @@
+package plock
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsHandler returns an http.Handler that renders every published Stats in
+// the Prometheus text exposition format. Each metric is labelled with the
+// name the Stats were published under, as lock, and with the mode or
+// conversion it counts
+func StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		b := bufio.NewWriter(w)
+		writePrometheus(b, publishedStats())
+		b.Flush()
+	})
+}
+
+// statsModes are the modes Stats keep, with their label values
+var statsModes = [...]struct {
+	mode  Mode
+	label string
+}{
+	{Read, "read"}, {Seek, "seek"}, {Write, "write"}, {Atomic, "atomic"},
+}
+
+// conversionLabels are the label values of conversions, in the same order
+var conversionLabels = [...]string{"r_to_s", "r_to_w", "r_to_a", "s_to_w", "s_to_r", "w_to_r", "w_to_s"}
+
+func writePrometheus(w *bufio.Writer, all []namedStats) {
+	snaps := make([]StatsSnapshot, len(all))
+	for i, n := range all {
+		snaps[i] = n.stats.Snapshot()
+	}
+
+	counter := func(name, help string, value func(m *ModeStats) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for i, n := range all {
+			for _, m := range statsModes {
+				fmt.Fprintf(w, "%s{lock=%s,mode=%q} %s\n", name, labelValue(n.name), m.label, value(snaps[i].Mode(m.mode)))
+			}
+		}
+	}
+	histogram := func(name, help string, modes []Mode, scale float64, value func(m *ModeStats) Histogram) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for i, n := range all {
+			for _, m := range statsModes {
+				if !hasMode(modes, m.mode) {
+					continue
+				}
+				labels := "lock=" + labelValue(n.name) + ",mode=" + strconv.Quote(m.label)
+				h := value(snaps[i].Mode(m.mode))
+				var cumulative uint64
+				for j, bound := range h.Bounds {
+					cumulative += h.Counts[j]
+					fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, formatFloat(float64(bound)/scale), cumulative)
+				}
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count())
+				fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(float64(h.Sum)/scale))
+				fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count())
+			}
+		}
+	}
+	seconds := float64(time.Second)
+	allModes := []Mode{Read, Seek, Write, Atomic}
+
+	counter("plock_acquisitions_total", "Locks acquired, not including conversions.", func(m *ModeStats) string {
+		return strconv.FormatUint(m.Acquisitions, 10)
+	})
+	counter("plock_contended_total", "Acquisitions and upgrades that had to wait.", func(m *ModeStats) string {
+		return strconv.FormatUint(m.Contended, 10)
+	})
+	counter("plock_held_seconds_total", "Time spent holding locks.", func(m *ModeStats) string {
+		return formatFloat(m.TotalHold.Seconds())
+	})
+
+	fmt.Fprintf(w, "# HELP plock_held Locks currently held.\n# TYPE plock_held gauge\n")
+	for i, n := range all {
+		for _, m := range statsModes {
+			fmt.Fprintf(w, "plock_held{lock=%s,mode=%q} %d\n", labelValue(n.name), m.label, snaps[i].Mode(m.mode).Held)
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP plock_conversions_total Locks converted between modes.\n# TYPE plock_conversions_total counter\n")
+	for i, n := range all {
+		c := snaps[i].Conversions
+		counts := [...]uint64{c.RToS, c.RToW, c.RToA, c.SToW, c.SToR, c.WToR, c.WToS}
+		for j, label := range conversionLabels {
+			fmt.Fprintf(w, "plock_conversions_total{lock=%s,conversion=%q} %d\n", labelValue(n.name), label, counts[j])
+		}
+	}
+
+	histogram("plock_wait_seconds", "Time acquisitions and upgrades waited.", allModes, seconds, func(m *ModeStats) Histogram {
+		return m.Wait
+	})
+	histogram("plock_hold_seconds", "Time each exclusive lock was held.", []Mode{Seek, Write}, seconds, func(m *ModeStats) Histogram {
+		return m.Hold
+	})
+	histogram("plock_attempts", "Attempts acquisitions and upgrades took.", allModes, 1, func(m *ModeStats) Histogram {
+		return m.Attempts
+	})
+}
+
+func hasMode(modes []Mode, m Mode) bool {
+	for _, mode := range modes {
+		if mode == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// labelEscaper escapes what the exposition format requires in label values:
+// backslashes, double quotes and newlines
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue quotes s as a label value. Graphviz quotes strings the same way
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,139 @@
+package plock
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// registry holds the PMutexes registered by Named, with the order they were
+// registered in
+var registry struct {
+	mu    sync.Mutex
+	locks map[*PMutex]uint64
+	next  uint64
+}
+
+// Named names the PMutex, as WithName, and registers it so that Registered
+// and RegistryHandler list it along with every other registered PMutex.
+// Several PMutexes may share a name: the stripes of a Striped created with
+// Named, for example. Registering also counts the goroutines waiting for the
+// PMutex.
+//
+// The registry keeps registered PMutexes alive until they are unregistered
+// with Unregister, except for those of a KeyedLocks, which are unregistered
+// as their keys are dropped
+func Named(name string) Option {
+	return func(o *options) {
+		o.name = name
+		o.registered = true
+	}
+}
+
+func register(p *PMutex) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.locks == nil {
+		registry.locks = make(map[*PMutex]uint64)
+	}
+	registry.locks[p] = registry.next
+	registry.next++
+}
+
+// Unregister removes p from the registry. It does nothing if p isn't
+// registered
+func (p *PMutex) Unregister() {
+	if p.opts == nil || !p.opts.registered {
+		return
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.locks, p)
+}
+
+// registration is a registered PMutex and its place in the order of
+// registration
+type registration struct {
+	p   *PMutex
+	seq uint64
+}
+
+// byRegistration sorts registrations by name and then in the order they were
+// made
+type byRegistration []registration
+
+func (r byRegistration) Len() int      { return len(r) }
+func (r byRegistration) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byRegistration) Less(i, j int) bool {
+	if a, b := r[i].p.opts.name, r[j].p.opts.name; a != b {
+		return a < b
+	}
+	return r[i].seq < r[j].seq
+}
+
+// Registered returns the registered PMutexes, sorted by name and then in the
+// order they were registered
+func Registered() []*PMutex {
+	registry.mu.Lock()
+	entries := make([]registration, 0, len(registry.locks))
+	for p, seq := range registry.locks {
+		entries = append(entries, registration{p, seq})
+	}
+	registry.mu.Unlock()
+
+	sort.Sort(byRegistration(entries))
+
+	locks := make([]*PMutex, len(entries))
+	for i, e := range entries {
+		locks[i] = e.p
+	}
+
+	return locks
+}
+
+// Waiters returns the number of goroutines blocked acquiring or upgrading
+// p that have failed at least one attempt. It returns 0 unless p was created
+// with Named
+func (p *PMutex) Waiters() int {
+	if p.opts == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt32(&p.opts.waiting))
+}
+
+// LockInfo describes a PMutex at one moment, for debugging
+type LockInfo struct {
+	Name string `json:"name"`
+	// Addr is the address of the lock word
+	Addr string `json:"addr"`
+	// State is the decoded lock word, and Description describes it as
+	// PMutex.String does
+	State       State  `json:"state"`
+	Description string `json:"description"`
+	// Holders are the goroutines holding the lock, if it was created with
+	// WithOwnership
+	Holders []Holder `json:"holders,omitempty"`
+	// Waiters is as returned by PMutex.Waiters
+	Waiters int `json:"waiters"`
+	// Waiting are the goroutines waiting for the lock, if it was created
+	// with WithOwnership
+	Waiting []Waiter `json:"waiting,omitempty"`
+}
+
+// Info describes p
+func (p *PMutex) Info() LockInfo {
+	s := p.State()
+	return LockInfo{
+		Name:        p.name(),
+		Addr:        fmt.Sprintf("%p", p.addr()),
+		State:       s,
+		Description: s.String(),
+		Holders:     p.Holders(),
+		Waiters:     p.Waiters(),
+		Waiting:     p.Waiting(),
+	}
+}
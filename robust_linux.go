@@ -0,0 +1,429 @@
+package plock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ErrOwnerDied is returned with a successfully acquired RobustPMutex when a
+// process died while holding it for writing (Write or Atomic). The protected
+// data may be inconsistent; it is reported until MarkConsistent is called
+var ErrOwnerDied = errors.New("plock: previous owner died holding the lock")
+
+// RobustSlots is the number of processes that may use one RobustPMutex
+const RobustSlots = 64
+
+// RobustSize is the number of bytes a RobustPMutex occupies in shared memory
+const RobustSize = int(unsafe.Sizeof(robustShared{}))
+
+// robustReapInterval is how long a blocked acquisition waits before checking
+// for dead owners again
+const robustReapInterval = 10 * time.Millisecond
+
+// robustShared is the layout of a RobustPMutex in shared memory
+type robustShared struct {
+	// word is the lock word, of which the first SharedWordSize bytes are used
+	word uint64
+	// owner is the pid of the seeker or writer, 0 if there is none, or -1
+	// while a dead owner is being reaped. Only the owner may set the Seek
+	// bits, so they tell whether the owner's change to the lock word was
+	// made. mode is the owner's robustState
+	owner int32
+	mode  int32
+	dirty int32
+	_     int32
+	slots [RobustSlots]robustSlot
+}
+
+// robustState is what the owner of a RobustPMutex holds, or is acquiring. It
+// is recorded before the lock word changes
+type robustState int32
+
+const (
+	robustNone robustState = iota
+	// robustSeeking adds the Seek and Read bits
+	robustSeeking
+	// robustPromoting adds the Seek bit to a Read Lock counted by the
+	// owner's slot
+	robustPromoting
+	// robustSeek holds the Seek and Read bits
+	robustSeek
+	// robustWriting adds the Write, Seek and Read bits, then waits for
+	// readers to leave
+	robustWriting
+	// robustUpgrading holds the Seek and Read bits, and adds the Write bit
+	robustUpgrading
+	// robustWrite holds the Write, Seek and Read bits
+	robustWrite
+)
+
+// robustSlot counts the read and atomic locks held by one process. draining
+// counts the Atomic Write Locks still waiting for readers to leave
+type robustSlot struct {
+	pid      int32
+	readers  int32
+	atomics  int32
+	draining int32
+}
+
+// RobustPMutex is a PMutex shared between processes that survives the death
+// of its holders, similar to a robust pthread mutex. It records the pid of
+// its seeker or writer, and each process's read and atomic locks, so that a
+// process blocked on the lock can detect holders that no longer exist and
+// release what they held, including locks they were still waiting for
+// readers to leave. If the dead process held a Write or Atomic lock,
+// acquisitions return ErrOwnerDied alongside the lock until the data has been
+// repaired and MarkConsistent called.
+//
+// Holders are checked with kill(pid, 0), so a pid reused by a new process
+// keeps its predecessor's locks. A process that dies in the instant between
+// changing the lock word and recording a Read or Atomic lock, or a conversion
+// to or from a Read lock, is not recoverable
+type RobustPMutex struct {
+	p    *PMutex
+	m    *robustShared
+	slot *robustSlot
+	pid  int32
+	mem  []byte
+}
+
+// NewRobustPMutexAt creates a RobustPMutex occupying RobustSize bytes of mem
+// at offset, which must be 8 byte aligned and initially zero. The same
+// requirements as NewPMutexAt apply
+func NewRobustPMutexAt(mem []byte, offset int, opts ...Option) (*RobustPMutex, error) {
+	if offset < 0 || offset > len(mem)-RobustSize {
+		return nil, errors.New("plock: robust lock is out of range")
+	}
+	if uintptr(unsafe.Pointer(&mem[offset]))%8 != 0 {
+		return nil, errors.New("plock: robust lock is misaligned")
+	}
+
+	p, err := NewPMutexAt(mem, offset, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RobustPMutex{
+		p:   p,
+		m:   (*robustShared)(unsafe.Pointer(&mem[offset])),
+		pid: int32(os.Getpid()),
+	}
+	if err = r.register(); err != nil {
+		p.Unregister()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// OpenRobust maps a RobustPMutex at offset in the file at path, as
+// OpenShared does. The lock must not be used after Close
+func OpenRobust(path string, offset int64, opts ...Option) (*RobustPMutex, error) {
+	if offset < 0 || offset%8 != 0 {
+		return nil, &os.PathError{Op: "plock", Path: path, Err: syscall.EINVAL}
+	}
+
+	mem, start, err := mapShared(path, offset, RobustSize)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRobustPMutexAt(mem, start, opts...)
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+	r.mem = mem
+
+	return r, nil
+}
+
+// Close unregisters a RobustPMutex opened by OpenRobust, if it was created
+// with Named, and unmaps it. It does not release any lock held through r
+func (r *RobustPMutex) Close() error {
+	if r.mem == nil {
+		return nil
+	}
+	r.p.Unregister()
+	err := syscall.Munmap(r.mem)
+	r.mem = nil
+
+	return err
+}
+
+// register claims a slot for the calling process, reusing one it already
+// owns
+func (r *RobustPMutex) register() error {
+	for i := range r.m.slots {
+		if atomic.LoadInt32(&r.m.slots[i].pid) == r.pid {
+			r.slot = &r.m.slots[i]
+			return nil
+		}
+	}
+
+	r.reap()
+	for i := range r.m.slots {
+		if atomic.CompareAndSwapInt32(&r.m.slots[i].pid, 0, r.pid) {
+			r.slot = &r.m.slots[i]
+			return nil
+		}
+	}
+
+	return errors.New("plock: robust lock has no free process slots")
+}
+
+// alive reports whether a process with the given pid exists
+func alive(pid int32) bool {
+	err := syscall.Kill(int(pid), 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// reap releases the locks held by dead processes
+func (r *RobustPMutex) reap() {
+	if pid := atomic.LoadInt32(&r.m.owner); pid > 0 && !alive(pid) && atomic.CompareAndSwapInt32(&r.m.owner, pid, -1) {
+		r.reapOwner(robustState(atomic.LoadInt32(&r.m.mode)))
+		atomic.StoreInt32(&r.m.mode, int32(robustNone))
+		atomic.StoreInt32(&r.m.owner, 0)
+	}
+
+	for i := range r.m.slots {
+		s := &r.m.slots[i]
+		pid := atomic.LoadInt32(&s.pid)
+		if pid <= 0 || alive(pid) || !atomic.CompareAndSwapInt32(&s.pid, pid, -1) {
+			continue
+		}
+
+		for n := atomic.SwapInt32(&s.readers, 0); n > 0; n-- {
+			r.p.RUnlock()
+		}
+		if n := atomic.SwapInt32(&s.atomics, 0); n > 0 {
+			atomic.StoreInt32(&r.m.dirty, 1)
+			for ; n > 0; n-- {
+				r.p.AUnlock()
+			}
+		}
+		for n := atomic.SwapInt32(&s.draining, 0); n > 0; n-- {
+			r.p.AUnlock()
+		}
+		atomic.StoreInt32(&s.pid, 0)
+	}
+}
+
+// reapOwner removes the bits a dead owner in state st had added to the lock
+// word. The state is recorded before the word changes, and only the owner
+// sets the Seek bits, so their absence means the owner either hadn't
+// added its bits yet or had already removed them. With the Seek bits held,
+// the Write bits can only belong to the owner
+func (r *RobustPMutex) reapOwner(st robustState) {
+	s := r.p.State()
+	if !s.Seeker {
+		return
+	}
+
+	switch st {
+	case robustPromoting:
+		r.p.SToR()
+	case robustSeeking, robustSeek:
+		r.p.SUnlock()
+	case robustWriting, robustUpgrading, robustWrite:
+		if s.Writers == 0 {
+			r.p.SUnlock()
+			break
+		}
+		if st == robustWrite {
+			atomic.StoreInt32(&r.m.dirty, 1)
+		}
+		r.p.WUnlock()
+	}
+}
+
+// acquire takes the lock with try or, failing that, with lock, reaping dead
+// holders every robustReapInterval until it succeeds
+func (r *RobustPMutex) acquire(try func() bool, lock func(context.Context) error) {
+	if try() {
+		return
+	}
+
+	for {
+		r.reap()
+		ctx, cancel := context.WithTimeout(context.Background(), robustReapInterval)
+		err := lock(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+}
+
+// until returns a function retrying try, waiting for the lock word to allow
+// w between attempts, until it succeeds or ctx is done
+func (r *RobustPMutex) until(try func() bool, w want) func(context.Context) error {
+	return func(ctx context.Context) error {
+		for i := 0; ; i++ {
+			gen := r.p.generation()
+			if try() {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			r.p.wait(ctx, w, i, gen)
+		}
+	}
+}
+
+// tryClaim makes the calling process the owner, if there is none
+func (r *RobustPMutex) tryClaim() bool {
+	return atomic.CompareAndSwapInt32(&r.m.owner, 0, r.pid)
+}
+
+// claim waits to become the owner, then records the state it's entering.
+// Only one seeker or writer may exist at a time, so this only waits while
+// another holds the lock in those modes or is acquiring it
+func (r *RobustPMutex) claim(st robustState) {
+	r.acquire(r.tryClaim, r.until(r.tryClaim, wantNoSeekerOrWriter))
+	r.enter(st)
+}
+
+// enter records the owner's new state
+func (r *RobustPMutex) enter(st robustState) {
+	atomic.StoreInt32(&r.m.mode, int32(st))
+}
+
+// disown gives up ownership, once the owner's bits have left the lock word
+func (r *RobustPMutex) disown() {
+	r.enter(robustNone)
+	atomic.StoreInt32(&r.m.owner, 0)
+}
+
+// status returns ErrOwnerDied if the lock is marked inconsistent
+func (r *RobustPMutex) status() error {
+	if atomic.LoadInt32(&r.m.dirty) != 0 {
+		return ErrOwnerDied
+	}
+
+	return nil
+}
+
+// MarkConsistent clears the inconsistent state reported by ErrOwnerDied. It
+// should be called once the protected data has been repaired, while holding
+// the Write lock
+func (r *RobustPMutex) MarkConsistent() {
+	atomic.StoreInt32(&r.m.dirty, 0)
+}
+
+// State returns the decoded lock word
+func (r *RobustPMutex) State() State {
+	return r.p.State()
+}
+
+// RLock acquires a read lock
+func (r *RobustPMutex) RLock() error {
+	r.acquire(r.p.TryRLock, r.p.RLockContext)
+	atomic.AddInt32(&r.slot.readers, 1)
+
+	return r.status()
+}
+
+// RUnlock releases a read lock
+func (r *RobustPMutex) RUnlock() {
+	atomic.AddInt32(&r.slot.readers, -1)
+	r.p.RUnlock()
+}
+
+// RToS upgrades a read lock to the seek lock
+func (r *RobustPMutex) RToS() error {
+	r.claim(robustPromoting)
+	r.acquire(r.p.TryRToS, r.p.RToSContext)
+	atomic.AddInt32(&r.slot.readers, -1)
+	r.enter(robustSeek)
+
+	return r.status()
+}
+
+// SLock acquires the seek lock
+func (r *RobustPMutex) SLock() error {
+	r.claim(robustSeeking)
+	r.acquire(r.p.TrySLock, r.p.SLockContext)
+	r.enter(robustSeek)
+
+	return r.status()
+}
+
+// SUnlock releases the seek lock
+func (r *RobustPMutex) SUnlock() {
+	r.p.SUnlock()
+	r.disown()
+}
+
+// SToR downgrades the seek lock to a read lock
+func (r *RobustPMutex) SToR() {
+	r.p.SToR()
+	atomic.AddInt32(&r.slot.readers, 1)
+	r.disown()
+}
+
+// SToW upgrades the seek lock to the write lock
+func (r *RobustPMutex) SToW() error {
+	r.enter(robustUpgrading)
+	r.acquire(r.p.TrySToW, r.p.SToWContext)
+	r.enter(robustWrite)
+
+	return r.status()
+}
+
+// WLock acquires the write lock
+func (r *RobustPMutex) WLock() error {
+	r.claim(robustWriting)
+	r.acquire(r.p.TryWLock, r.p.WLockContext)
+	r.enter(robustWrite)
+
+	return r.status()
+}
+
+// WUnlock releases the write lock
+func (r *RobustPMutex) WUnlock() {
+	r.p.WUnlock()
+	r.disown()
+}
+
+// WToR downgrades the write lock to a read lock
+func (r *RobustPMutex) WToR() {
+	r.p.WToR()
+	atomic.AddInt32(&r.slot.readers, 1)
+	r.disown()
+}
+
+// WToS downgrades the write lock to the seek lock
+func (r *RobustPMutex) WToS() {
+	r.p.WToS()
+	r.enter(robustSeek)
+}
+
+// ALock acquires an atomic write lock. While it waits for readers to leave,
+// the Write bit it took is counted as draining, so that it is released if
+// the process dies
+func (r *RobustPMutex) ALock() error {
+	r.p.acquiring(Atomic)
+	r.acquire(r.p.tryALock, r.until(r.p.tryALock, wantNoSeeker))
+	atomic.AddInt32(&r.slot.draining, 1)
+
+	drained := func() bool { return r.p.grantable(wantNoReaders) }
+	r.acquire(drained, r.until(drained, wantNoReaders))
+	atomic.AddInt32(&r.slot.draining, -1)
+	atomic.AddInt32(&r.slot.atomics, 1)
+	r.p.acquired(Atomic, nil)
+
+	return r.status()
+}
+
+// AUnlock releases an atomic write lock
+func (r *RobustPMutex) AUnlock() {
+	atomic.AddInt32(&r.slot.atomics, -1)
+	r.p.AUnlock()
+}
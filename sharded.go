@@ -0,0 +1,209 @@
+package plock
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// cacheLineSize is the assumed size of a CPU cache line. Values that are
+// written concurrently are padded to it, so that they don't share a line
+const cacheLineSize = 64
+
+// RToken identifies where a Read Lock on a ShardedPMutex is recorded. It is
+// returned when a Read Lock is acquired, and must be passed back when that
+// Read Lock is released or upgraded
+type RToken int
+
+// centralRToken is the RToken of a Read Lock held on the central PMutex
+const centralRToken RToken = -1
+
+type readerShard struct {
+	n int32
+	_ [cacheLineSize - 4]byte
+}
+
+// ShardedPMutex is a PMutex for read-mostly workloads. Read Locks are
+// counted on one of several cache-line-padded shards rather than on a single
+// lock word, so readers on different CPUs don't contend with each other.
+// Seek, Write and Atomic Write Locks behave exactly as they do on PMutex;
+// writers have to scan every shard to wait for readers to leave, which makes
+// them more expensive.
+//
+// The zero value is an unlocked ShardedPMutex with no shards, which behaves
+// like a PMutex. Use NewShardedPMutex to create one with shards
+type ShardedPMutex struct {
+	p      PMutex
+	shards []readerShard
+}
+
+// NewShardedPMutex creates a ShardedPMutex with the given number of reader
+// shards. If shards is less than 1, runtime.GOMAXPROCS(0) shards are used
+func NewShardedPMutex(shards int) *ShardedPMutex {
+	if shards < 1 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+
+	return &ShardedPMutex{shards: make([]readerShard, shards)}
+}
+
+// shard picks a shard for the calling goroutine. Goroutines don't have IDs,
+// but each has its own stack: hashing the address of a local variable
+// spreads goroutines across the shards, and keeps a goroutine on the same
+// shard while its stack doesn't move
+func (m *ShardedPMutex) shard() RToken {
+	var x byte
+	h := uint64(uintptr(unsafe.Pointer(&x))>>10) * 0x9E3779B97F4A7C15
+
+	return RToken((h >> 32) % uint64(len(m.shards)))
+}
+
+// shardsEmpty reports whether every shard is empty
+func (m *ShardedPMutex) shardsEmpty() bool {
+	for i := range m.shards {
+		if atomic.LoadInt32(&m.shards[i].n) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// drain waits for every shard to be empty. The caller must hold the Write
+// bit of the central PMutex, which keeps new shard readers out
+func (m *ShardedPMutex) drain() {
+	for !m.shardsEmpty() {
+		runtime.Gosched()
+	}
+}
+
+// TryRLock attempts to acquire a Read Lock without blocking. If ok is true,
+// the lock was acquired and t must be passed to RUnlock
+func (m *ShardedPMutex) TryRLock() (t RToken, ok bool) {
+	if len(m.shards) == 0 {
+		return centralRToken, m.p.TryRLock()
+	}
+
+	t = m.shard()
+	s := &m.shards[t]
+	atomic.AddInt32(&s.n, 1)
+
+	// this pairs with writers setting the Write bit before scanning shards:
+	// either they see our count, or we see their bit
+	if m.p.grantable(wantNoWriter) {
+		return t, true
+	}
+	atomic.AddInt32(&s.n, -1)
+
+	return t, false
+}
+
+// RLock acquires a Read Lock, blocking while a writer is present. The
+// returned RToken must be passed to RUnlock, or to an upgrade
+func (m *ShardedPMutex) RLock() RToken {
+	for {
+		if t, ok := m.TryRLock(); ok {
+			return t
+		}
+		runtime.Gosched()
+	}
+}
+
+// RUnlock releases the Read Lock identified by t
+func (m *ShardedPMutex) RUnlock(t RToken) {
+	if t == centralRToken {
+		m.p.RUnlock()
+		return
+	}
+	atomic.AddInt32(&m.shards[t].n, -1)
+}
+
+// RToS upgrades the Read Lock identified by t to a Seek Lock
+func (m *ShardedPMutex) RToS(t RToken) {
+	if t == centralRToken {
+		m.p.RToS()
+		return
+	}
+
+	// the Seek Lock carries its own Read Lock on the central PMutex, so the
+	// shard can be let go once it is held
+	m.p.SLock()
+	atomic.AddInt32(&m.shards[t].n, -1)
+}
+
+// TrySLock attempts to acquire a Seek Lock without blocking. It returns true
+// if the lock was acquired
+func (m *ShardedPMutex) TrySLock() bool {
+	return m.p.TrySLock()
+}
+
+// SLock acquires a Seek Lock. Readers on the shards are not affected
+func (m *ShardedPMutex) SLock() {
+	m.p.SLock()
+}
+
+// SUnlock releases an existing Seek Lock
+func (m *ShardedPMutex) SUnlock() {
+	m.p.SUnlock()
+}
+
+// SToR downgrades an existing Seek Lock to a Read Lock, returning its RToken
+func (m *ShardedPMutex) SToR() RToken {
+	m.p.SToR()
+	return centralRToken
+}
+
+// SToW upgrades an existing Seek Lock to a Write Lock, waiting for readers
+// on every shard to leave
+func (m *ShardedPMutex) SToW() {
+	m.p.SToW()
+	m.drain()
+}
+
+// TryWLock attempts to acquire a Write Lock without blocking. It returns true
+// if the lock was acquired
+func (m *ShardedPMutex) TryWLock() bool {
+	if !m.p.TryWLock() {
+		return false
+	}
+	if m.shardsEmpty() {
+		return true
+	}
+	m.p.WUnlock()
+
+	return false
+}
+
+// WLock acquires a Write Lock, blocking until readers on every shard leave
+func (m *ShardedPMutex) WLock() {
+	m.p.WLock()
+	m.drain()
+}
+
+// WUnlock releases an existing Write Lock
+func (m *ShardedPMutex) WUnlock() {
+	m.p.WUnlock()
+}
+
+// WToR downgrades an existing Write Lock to a Read Lock, returning its RToken
+func (m *ShardedPMutex) WToR() RToken {
+	m.p.WToR()
+	return centralRToken
+}
+
+// WToS downgrades an existing Write Lock to a Seek Lock
+func (m *ShardedPMutex) WToS() {
+	m.p.WToS()
+}
+
+// ALock acquires an Atomic Write Lock, blocking until readers on every shard
+// leave
+func (m *ShardedPMutex) ALock() {
+	m.p.ALock()
+	m.drain()
+}
+
+// AUnlock releases an Atomic Write Lock
+func (m *ShardedPMutex) AUnlock() {
+	m.p.AUnlock()
+}
@@ -0,0 +1,49 @@
+package plock
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// SharedWordSize is the number of bytes a shared lock word occupies. It is
+// also the alignment the word requires
+const SharedWordSize = int(unsafe.Sizeof(PMutex{}.lock))
+
+// NewPMutexAt creates a PMutex whose lock word is stored in mem at offset,
+// rather than in the PMutex itself. Every PMutex created over the same bytes,
+// including in other processes mapping the same shared memory, is the same
+// lock. mem must stay valid, and must not move, for as long as the PMutex is
+// used; memory returned by mmap satisfies this, memory allocated by Go does
+// not.
+//
+// The word must be zero before the lock is first used, and every process
+// sharing it must have the same word size. The lock word carries all of the
+// lock's state, so the remaining options apply only to the calling process.
+// WithParking is rejected, as parked goroutines would not be woken when
+// another process releases the lock. A PMutex created with Named must be
+// unregistered before mem is unmapped
+func NewPMutexAt(mem []byte, offset int, opts ...Option) (*PMutex, error) {
+	if offset < 0 || offset > len(mem)-SharedWordSize {
+		return nil, errors.New("plock: shared lock word is out of range")
+	}
+
+	word := unsafe.Pointer(&mem[offset])
+	if uintptr(word)%uintptr(SharedWordSize) != 0 {
+		return nil, errors.New("plock: shared lock word is misaligned")
+	}
+
+	o := newOptions(opts)
+	if o == nil {
+		o = &options{}
+	} else if o.queue != nil {
+		return nil, errors.New("plock: parking is not supported on shared locks")
+	}
+	o.word = word
+
+	p := &PMutex{opts: o}
+	if o.registered {
+		register(p)
+	}
+
+	return p, nil
+}
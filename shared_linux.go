@@ -0,0 +1,79 @@
+package plock
+
+import (
+	"os"
+	"syscall"
+)
+
+// SharedPMutex is a PMutex whose lock word lives in a memory-mapped file, so
+// that it may be shared by every process that opens the same file and offset
+type SharedPMutex struct {
+	*PMutex
+	mem []byte
+}
+
+// OpenShared maps the lock word at offset in the file at path, creating the
+// file, or extending it with zeroes, as needed. offset must be a multiple of
+// SharedWordSize. The lock must not be used after Close
+func OpenShared(path string, offset int64, opts ...Option) (*SharedPMutex, error) {
+	if offset < 0 {
+		return nil, &os.PathError{Op: "plock", Path: path, Err: syscall.EINVAL}
+	}
+
+	mem, start, err := mapShared(path, offset, SharedWordSize)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewPMutexAt(mem, start, opts...)
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+
+	return &SharedPMutex{PMutex: p, mem: mem}, nil
+}
+
+// mapShared maps size bytes at offset in the file at path, creating or
+// extending the file as needed. It returns the mapping and the position of
+// offset within it
+func mapShared(path string, offset int64, size int) ([]byte, int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	end := offset + int64(size)
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if fi.Size() < end {
+		if err = f.Truncate(end); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// mmap offsets must be page aligned
+	page := offset &^ int64(os.Getpagesize()-1)
+	mem, err := syscall.Mmap(int(f.Fd()), page, int(end-page), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, 0, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+
+	return mem, int(offset - page), nil
+}
+
+// Close unregisters s, if it was created with Named, and unmaps the lock
+// word. It does not release any lock held through s
+func (s *SharedPMutex) Close() error {
+	if s.mem == nil {
+		return nil
+	}
+	s.Unregister()
+	err := syscall.Munmap(s.mem)
+	s.mem = nil
+
+	return err
+}
@@ -0,0 +1,157 @@
+package plock
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Mode is the overall state of a PMutex, as decoded from its lock word
+type Mode int
+
+const (
+	// Unlocked means no lock of any kind is held or being acquired
+	Unlocked Mode = iota
+	// Read means only Read Locks are held
+	Read
+	// Seek means a Seek Lock is held, possibly alongside Read Locks
+	Seek
+	// Write means a Write Lock is held, and all readers have left
+	Write
+	// Atomic means Atomic Write Locks are held, and all readers have left
+	Atomic
+	// WriteDraining means a Write or Atomic Write Lock has been taken, but
+	// its holder is still waiting for readers to leave
+	WriteDraining
+)
+
+var modeNames = [...]string{
+	Unlocked:      "Unlocked",
+	Read:          "Read",
+	Seek:          "Seek",
+	Write:         "Write",
+	Atomic:        "Atomic",
+	WriteDraining: "WriteDraining",
+}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return "Mode(" + strconv.Itoa(int(m)) + ")"
+	}
+	return modeNames[m]
+}
+
+// MarshalText encodes the Mode as its name
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes a Mode from its name
+func (m *Mode) UnmarshalText(b []byte) error {
+	for i, name := range modeNames {
+		if name == string(b) {
+			*m = Mode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("plock: unknown mode %q", b)
+}
+
+// State is a decoded snapshot of a PMutex's lock word
+type State struct {
+	// Mode summarises the fields below
+	Mode Mode `json:"mode"`
+
+	// Readers is the number of Read Locks held, including the Read Lock
+	// that every Seek and Write Lock implicitly holds
+	Readers int `json:"readers"`
+
+	// Seeker is true when a Seek or Write Lock is held
+	Seeker bool `json:"seeker"`
+
+	// Writers is the number of Write and Atomic Write Locks held
+	Writers int `json:"writers"`
+
+	// Word is the raw lock word. On 32 bit platforms, only the low 32 bits
+	// are used. Bit 0 is set while goroutines sleep on the lock (WithFutex)
+	Word uint64 `json:"word"`
+}
+
+// newState builds a State from the fields decoded out of a lock word. The
+// lock word is always the size of a uintptr
+func newState(word uintptr, readers, writers int, seeker bool) State {
+	s := State{
+		Readers: readers,
+		Seeker:  seeker,
+		Writers: writers,
+		Word:    uint64(word),
+	}
+
+	switch {
+	case readers == 0 && writers == 0 && !seeker:
+		s.Mode = Unlocked
+	case writers != 0 && seeker:
+		s.Mode = Write
+		if readers != 1 {
+			s.Mode = WriteDraining
+		}
+	case writers != 0:
+		s.Mode = Atomic
+		if readers != 0 {
+			s.Mode = WriteDraining
+		}
+	case seeker:
+		s.Mode = Seek
+	default:
+		s.Mode = Read
+	}
+
+	return s
+}
+
+// String describes the state in the same terms as PMutex.String
+func (s State) String() string {
+	if s.Mode == Unlocked {
+		return "U"
+	}
+
+	hasWriter := s.Writers != 0
+	hasSeeker := s.Seeker
+	hasReader := s.Readers != 0
+
+	if hasWriter && !hasSeeker && !hasReader {
+		return fmt.Sprintf("A; writers: %d", s.Writers)
+	}
+
+	if hasReader && !hasSeeker && !hasWriter {
+		if s.Readers == 1 {
+			return "R; readers: self only"
+		}
+
+		return fmt.Sprintf("R; readers: %d", s.Readers-1)
+	}
+
+	str := ""
+	if hasReader {
+		str += "R"
+	}
+	if hasSeeker {
+		str += "+S"
+	}
+	if hasWriter {
+		if s.Readers == 1 {
+			str += "+W; readers: self only"
+		} else {
+			str += fmt.Sprintf("+W; waiting for readers: %d", s.Readers-1)
+		}
+	}
+
+	return str
+}
+
+// String returns a string representing the internal lock state
+// The string built by this function reflects a single moment in time,
+// _NOT_ the current moment; i.e. Do NOT rely on this for anything other
+// than debugging. Use State to inspect the lock programmatically
+func (p *PMutex) String() string {
+	return fmt.Sprintf("Addr: %d; %s", p.addr(), p.State())
+}
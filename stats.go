@@ -0,0 +1,359 @@
+package plock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WaitBounds are the upper bounds of the buckets of the wait and hold time
+// histograms: powers of 4 from 1µs to about 1s
+var WaitBounds = func() []time.Duration {
+	bounds := make([]time.Duration, 11)
+	for i := range bounds {
+		bounds[i] = time.Microsecond << (2 * uint(i))
+	}
+	return bounds
+}()
+
+// AttemptBounds are the upper bounds of the buckets of the attempts
+// histograms: powers of 2 from 1 to 1024
+var AttemptBounds = func() []int64 {
+	bounds := make([]int64, 11)
+	for i := range bounds {
+		bounds[i] = 1 << uint(i)
+	}
+	return bounds
+}()
+
+// histBuckets is the number of buckets of every histogram, including the last
+// one, which holds values above every bound
+const histBuckets = 12
+
+// histogram is a fixed-bucket histogram, updated atomically. Its fields are
+// all 64 bit, so that they stay aligned on 32 bit platforms
+type histogram struct {
+	counts [histBuckets]uint64
+	sum    uint64
+}
+
+func (h *histogram) add(v int64, bound func(i int) int64) {
+	i := 0
+	for i < histBuckets-1 && v > bound(i) {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(v))
+}
+
+func waitBound(i int) int64 { return int64(WaitBounds[i]) }
+
+func attemptBound(i int) int64 { return AttemptBounds[i] }
+
+func (h *histogram) snapshot(bounds []int64) Histogram {
+	s := Histogram{Bounds: bounds, Counts: make([]uint64, histBuckets)}
+	for i := range s.Counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	s.Sum = int64(atomic.LoadUint64(&h.sum))
+
+	return s
+}
+
+// modeStats are the statistics of one lock mode
+type modeStats struct {
+	acquisitions uint64
+	contended    uint64
+	// entered and left count every time the mode is entered and left,
+	// including by conversions; holdSum is the sum of the times it was
+	// left, minus the sum of the times it was entered
+	entered  uint64
+	left     uint64
+	holdSum  int64
+	wait     histogram
+	hold     histogram
+	attempts histogram
+}
+
+// conversions lists the conversions counted by Stats, in the order of the
+// fields of Conversions. The first ones, up to upgrades, may wait
+var conversions = [...][2]Mode{
+	{Read, Seek}, {Read, Write}, {Read, Atomic}, {Seek, Write},
+	{Seek, Read}, {Write, Read}, {Write, Seek},
+}
+
+const upgrades = 4
+
+// Stats collects statistics about the PMutexes given it with WithStats: how
+// often each mode is acquired, how long acquisitions wait and how many
+// attempts they take, and how long each mode is held. Counters are updated
+// atomically, without locking, at the cost of reading the clock on every
+// transition. Several PMutexes may share one Stats, for example the stripes of
+// a Striped.
+//
+// A Stats must be created with NewStats
+type Stats struct {
+	modes       [4]modeStats
+	conversions [len(conversions)]uint64
+	// base is the StatsSnapshot taken by the last Reset, which Snapshot
+	// subtracts. The counters themselves are never rewritten, so a Reset
+	// can't lose the updates of transitions running alongside it
+	base atomic.Value
+}
+
+// NewStats creates an empty Stats
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// lockStats is the state WithStats keeps per PMutex
+type lockStats struct {
+	// since is when the current Seek or Write Lock was entered, by nanotime
+	since int64
+	stats *Stats
+}
+
+// WithStats makes the PMutex collect statistics into s
+func WithStats(s *Stats) Option {
+	return func(o *options) {
+		o.stats = &lockStats{stats: s}
+	}
+}
+
+// mode returns the statistics of mode m, which must be one of Read, Seek,
+// Write or Atomic
+func (s *Stats) mode(m Mode) *modeStats {
+	return &s.modes[m-Read]
+}
+
+// enter records that the lock entered mode m at now
+func (l *lockStats) enter(m Mode, now int64) {
+	ms := l.stats.mode(m)
+	atomic.AddUint64(&ms.entered, 1)
+	atomic.AddInt64(&ms.holdSum, -now)
+	if m == Seek || m == Write {
+		atomic.StoreInt64(&l.since, now)
+	}
+}
+
+// leave records that the lock left mode m at now
+func (l *lockStats) leave(m Mode, now int64) {
+	ms := l.stats.mode(m)
+	atomic.AddInt64(&ms.holdSum, now)
+	atomic.AddUint64(&ms.left, 1)
+	if m == Seek || m == Write {
+		ms.hold.add(now-atomic.LoadInt64(&l.since), waitBound)
+	}
+}
+
+// waited records the waits of an acquisition or upgrade into mode m
+func (l *lockStats) waited(m Mode, w *waitStart, now int64) {
+	ms := l.stats.mode(m)
+	if w == nil || w.waits == 0 {
+		ms.wait.add(0, waitBound)
+		ms.attempts.add(1, attemptBound)
+		return
+	}
+
+	atomic.AddUint64(&ms.contended, 1)
+	ms.wait.add(now-w.at, waitBound)
+	ms.attempts.add(int64(w.waits)+1, attemptBound)
+}
+
+func (l *lockStats) acquired(m Mode, w *waitStart) {
+	now := nanotime()
+	atomic.AddUint64(&l.stats.mode(m).acquisitions, 1)
+	l.waited(m, w, now)
+	l.enter(m, now)
+}
+
+func (l *lockStats) released(m Mode) {
+	l.leave(m, nanotime())
+}
+
+func (l *lockStats) converted(from, to Mode, w *waitStart) {
+	now := nanotime()
+	for i, c := range conversions {
+		if c == [2]Mode{from, to} {
+			atomic.AddUint64(&l.stats.conversions[i], 1)
+			if i < upgrades {
+				l.waited(to, w, now)
+			}
+		}
+	}
+	l.leave(from, now)
+	l.enter(to, now)
+}
+
+// Histogram is a snapshot of a fixed-bucket histogram. Counts[i] counts the
+// values no greater than Bounds[i] and greater than the previous bound; the
+// last count, which has no bound, counts values above every bound
+type Histogram struct {
+	Bounds []int64  `json:"bounds"`
+	Counts []uint64 `json:"counts"`
+	// Sum is the sum of every value counted
+	Sum int64 `json:"sum"`
+}
+
+// Count returns the number of values counted
+func (h Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+
+	return n
+}
+
+// Sub returns the values counted by h since prev was taken
+func (h Histogram) Sub(prev Histogram) Histogram {
+	d := Histogram{Bounds: h.Bounds, Counts: make([]uint64, len(h.Counts)), Sum: h.Sum - prev.Sum}
+	for i := range d.Counts {
+		d.Counts[i] = h.Counts[i]
+		if i < len(prev.Counts) {
+			d.Counts[i] -= prev.Counts[i]
+		}
+	}
+
+	return d
+}
+
+// ModeStats are the statistics of one lock mode
+type ModeStats struct {
+	// Acquisitions counts the locks acquired in this mode, not including
+	// conversions into it
+	Acquisitions uint64 `json:"acquisitions"`
+	// Contended counts the acquisitions and upgrades into this mode that
+	// had to wait
+	Contended uint64 `json:"contended"`
+	// Held is the number of locks held in this mode when the snapshot was
+	// taken
+	Held int64 `json:"held"`
+	// TotalHold is the time spent holding locks in this mode, including
+	// locks still held
+	TotalHold time.Duration `json:"total_hold_ns"`
+	// Wait is a histogram of the nanoseconds acquisitions and upgrades into
+	// this mode waited. Acquisitions that didn't wait count as 0
+	Wait Histogram `json:"wait_ns"`
+	// Hold is a histogram of the nanoseconds each lock was held in this
+	// mode. Read and Atomic Write Locks are shared, so only TotalHold is
+	// kept for them
+	Hold Histogram `json:"hold_ns"`
+	// Attempts is a histogram of the attempts acquisitions and upgrades
+	// into this mode took
+	Attempts Histogram `json:"attempts"`
+}
+
+// Sub returns the statistics collected since prev was taken. Held is kept
+// as is
+func (s ModeStats) Sub(prev ModeStats) ModeStats {
+	return ModeStats{
+		Acquisitions: s.Acquisitions - prev.Acquisitions,
+		Contended:    s.Contended - prev.Contended,
+		Held:         s.Held,
+		TotalHold:    s.TotalHold - prev.TotalHold,
+		Wait:         s.Wait.Sub(prev.Wait),
+		Hold:         s.Hold.Sub(prev.Hold),
+		Attempts:     s.Attempts.Sub(prev.Attempts),
+	}
+}
+
+// Conversions counts the conversions between modes
+type Conversions struct {
+	RToS uint64 `json:"r_to_s"`
+	RToW uint64 `json:"r_to_w"`
+	RToA uint64 `json:"r_to_a"`
+	SToW uint64 `json:"s_to_w"`
+	SToR uint64 `json:"s_to_r"`
+	WToR uint64 `json:"w_to_r"`
+	WToS uint64 `json:"w_to_s"`
+}
+
+// StatsSnapshot is a snapshot of a Stats
+type StatsSnapshot struct {
+	Read        ModeStats   `json:"read"`
+	Seek        ModeStats   `json:"seek"`
+	Write       ModeStats   `json:"write"`
+	Atomic      ModeStats   `json:"atomic"`
+	Conversions Conversions `json:"conversions"`
+}
+
+// Mode returns the statistics of mode m, which must be one of Read, Seek,
+// Write or Atomic
+func (s *StatsSnapshot) Mode(m Mode) *ModeStats {
+	return [...]*ModeStats{&s.Read, &s.Seek, &s.Write, &s.Atomic}[m-Read]
+}
+
+// Sub returns the statistics collected since prev was taken, for comparing
+// intervals without resetting s
+func (s StatsSnapshot) Sub(prev StatsSnapshot) StatsSnapshot {
+	c, p := s.Conversions, prev.Conversions
+	return StatsSnapshot{
+		Read:   s.Read.Sub(prev.Read),
+		Seek:   s.Seek.Sub(prev.Seek),
+		Write:  s.Write.Sub(prev.Write),
+		Atomic: s.Atomic.Sub(prev.Atomic),
+		Conversions: Conversions{
+			RToS: c.RToS - p.RToS,
+			RToW: c.RToW - p.RToW,
+			RToA: c.RToA - p.RToA,
+			SToW: c.SToW - p.SToW,
+			SToR: c.SToR - p.SToR,
+			WToR: c.WToR - p.WToR,
+			WToS: c.WToS - p.WToS,
+		},
+	}
+}
+
+// Snapshot returns the statistics collected since the last Reset, or since s
+// was created. The counters are read one at a time, so the snapshot may be
+// slightly inconsistent while the locks are in use
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := s.total()
+	if base, ok := s.base.Load().(StatsSnapshot); ok {
+		snap = snap.Sub(base)
+	}
+
+	return snap
+}
+
+// total returns the statistics collected since s was created
+func (s *Stats) total() StatsSnapshot {
+	waits := make([]int64, len(WaitBounds))
+	for i := range waits {
+		waits[i] = waitBound(i)
+	}
+
+	now := nanotime()
+	var snap StatsSnapshot
+	for _, m := range []Mode{Read, Seek, Write, Atomic} {
+		ms := s.mode(m)
+		held := int64(atomic.LoadUint64(&ms.entered) - atomic.LoadUint64(&ms.left))
+		*snap.Mode(m) = ModeStats{
+			Acquisitions: atomic.LoadUint64(&ms.acquisitions),
+			Contended:    atomic.LoadUint64(&ms.contended),
+			Held:         held,
+			TotalHold:    time.Duration(atomic.LoadInt64(&ms.holdSum) + held*now),
+			Wait:         ms.wait.snapshot(waits),
+			Hold:         ms.hold.snapshot(waits),
+			Attempts:     ms.attempts.snapshot(AttemptBounds),
+		}
+	}
+
+	counts := make([]uint64, len(conversions))
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&s.conversions[i])
+	}
+	snap.Conversions = Conversions{
+		RToS: counts[0], RToW: counts[1], RToA: counts[2], SToW: counts[3],
+		SToR: counts[4], WToR: counts[5], WToS: counts[6],
+	}
+
+	return snap
+}
+
+// Reset discards the statistics collected so far, by recording them as the
+// baseline later snapshots are taken from. Locks held across a Reset are
+// still counted as held; their time held before the Reset is discarded
+func (s *Stats) Reset() {
+	s.base.Store(s.total())
+}
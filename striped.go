@@ -0,0 +1,192 @@
+package plock
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+)
+
+// DefaultStripes is the number of stripes NewStriped uses when asked for
+// fewer than 1
+const DefaultStripes = 64
+
+type paddedPMutex struct {
+	PMutex
+	_ [cacheLineSize - unsafe.Sizeof(PMutex{})%cacheLineSize]byte
+}
+
+// Striped is a fixed size table of PMutexes, each on its own cache line,
+// standing in for one lock per key: every key maps to one of the stripes by
+// its hash. Keys that share a stripe also share its lock, so acquiring two
+// keys at once must go through LockKeys to avoid deadlocking
+type Striped struct {
+	stripes []paddedPMutex
+	hash    func(key string) uint64
+}
+
+// NewStriped creates a Striped with n stripes, each configured by opts. If
+// hash is nil, FNV-1a is used. If n is less than 1, DefaultStripes is used
+func NewStriped(n int, hash func(key string) uint64, opts ...Option) *Striped {
+	if n < 1 {
+		n = DefaultStripes
+	}
+	if hash == nil {
+		hash = fnv1a
+	}
+
+	s := &Striped{
+		stripes: make([]paddedPMutex, n),
+		hash:    hash,
+	}
+	for i := range s.stripes {
+		s.stripes[i].configure(opts)
+	}
+
+	return s
+}
+
+// fnv1a is the 64 bit FNV-1a hash of key
+func fnv1a(key string) uint64 {
+	const (
+		offset uint64 = 14695981039346656037
+		prime  uint64 = 1099511628211
+	)
+
+	h := offset
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime
+	}
+
+	return h
+}
+
+// Len returns the number of stripes
+func (s *Striped) Len() int {
+	return len(s.stripes)
+}
+
+// Index returns the index of the stripe key maps to
+func (s *Striped) Index(key string) int {
+	return int(s.hash(key) % uint64(len(s.stripes)))
+}
+
+// Get returns the PMutex key maps to
+func (s *Striped) Get(key string) *PMutex {
+	return &s.stripes[s.Index(key)].PMutex
+}
+
+// RLockKey acquires a Read Lock on the stripe of key
+func (s *Striped) RLockKey(key string) { s.Get(key).RLock() }
+
+// RUnlockKey releases a Read Lock on the stripe of key
+func (s *Striped) RUnlockKey(key string) { s.Get(key).RUnlock() }
+
+// SLockKey acquires a Seek Lock on the stripe of key
+func (s *Striped) SLockKey(key string) { s.Get(key).SLock() }
+
+// SUnlockKey releases a Seek Lock on the stripe of key
+func (s *Striped) SUnlockKey(key string) { s.Get(key).SUnlock() }
+
+// WLockKey acquires a Write Lock on the stripe of key
+func (s *Striped) WLockKey(key string) { s.Get(key).WLock() }
+
+// WUnlockKey releases a Write Lock on the stripe of key
+func (s *Striped) WUnlockKey(key string) { s.Get(key).WUnlock() }
+
+// ALockKey acquires an Atomic Write Lock on the stripe of key
+func (s *Striped) ALockKey(key string) { s.Get(key).ALock() }
+
+// AUnlockKey releases an Atomic Write Lock on the stripe of key
+func (s *Striped) AUnlockKey(key string) { s.Get(key).AUnlock() }
+
+// RToSKey upgrades a Read Lock on the stripe of key to a Seek Lock
+func (s *Striped) RToSKey(key string) { s.Get(key).RToS() }
+
+// RToWKey upgrades a Read Lock on the stripe of key to a Write Lock
+func (s *Striped) RToWKey(key string) { s.Get(key).RToW() }
+
+// RToAKey upgrades a Read Lock on the stripe of key to an Atomic Write Lock
+func (s *Striped) RToAKey(key string) { s.Get(key).RToA() }
+
+// SToWKey upgrades a Seek Lock on the stripe of key to a Write Lock
+func (s *Striped) SToWKey(key string) { s.Get(key).SToW() }
+
+// SToRKey downgrades a Seek Lock on the stripe of key to a Read Lock
+func (s *Striped) SToRKey(key string) { s.Get(key).SToR() }
+
+// WToRKey downgrades a Write Lock on the stripe of key to a Read Lock
+func (s *Striped) WToRKey(key string) { s.Get(key).WToR() }
+
+// WToSKey downgrades a Write Lock on the stripe of key to a Seek Lock
+func (s *Striped) WToSKey(key string) { s.Get(key).WToS() }
+
+// indexes returns the distinct stripe indexes of keys, in ascending order
+func (s *Striped) indexes(keys []string) []int {
+	idx := make([]int, 0, len(keys))
+	for _, k := range keys {
+		idx = append(idx, s.Index(k))
+	}
+	sort.Ints(idx)
+
+	n := 0
+	for i := range idx {
+		if i == 0 || idx[i] != idx[n-1] {
+			idx[n] = idx[i]
+			n++
+		}
+	}
+
+	return idx[:n]
+}
+
+// LockKeys acquires the stripes of every key in mode m, which must be one of
+// Read, Seek, Write or Atomic. Stripes are always taken in ascending order,
+// and a stripe shared by several keys is only taken once, so concurrent
+// callers can't deadlock each other. Release them with UnlockKeys, passing
+// the same mode and keys
+func (s *Striped) LockKeys(m Mode, keys ...string) {
+	for _, i := range s.indexes(keys) {
+		lockMode(&s.stripes[i].PMutex, m)
+	}
+}
+
+// UnlockKeys releases the stripes acquired by LockKeys
+func (s *Striped) UnlockKeys(m Mode, keys ...string) {
+	idx := s.indexes(keys)
+	for i := len(idx) - 1; i >= 0; i-- {
+		unlockMode(&s.stripes[idx[i]].PMutex, m)
+	}
+}
+
+// lockMode acquires p in mode m
+func lockMode(p *PMutex, m Mode) {
+	switch m {
+	case Read:
+		p.RLock()
+	case Seek:
+		p.SLock()
+	case Write:
+		p.WLock()
+	case Atomic:
+		p.ALock()
+	default:
+		panic(fmt.Sprintf("plock: can't lock in mode %v", m))
+	}
+}
+
+// unlockMode releases p, held in mode m
+func unlockMode(p *PMutex, m Mode) {
+	switch m {
+	case Read:
+		p.RUnlock()
+	case Seek:
+		p.SUnlock()
+	case Write:
+		p.WUnlock()
+	case Atomic:
+		p.AUnlock()
+	default:
+		panic(fmt.Sprintf("plock: can't unlock mode %v", m))
+	}
+}
@@ -0,0 +1,87 @@
+package plock_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestBiasedPMutex(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	n := 1000
+	if testing.Short() {
+		n = 5
+	}
+
+	hammerTokenPMutex(&plock.BiasedPMutex{}, 1, 3, n)
+	hammerTokenPMutex(&plock.BiasedPMutex{}, 4, 3, n)
+	hammerTokenPMutex(&plock.BiasedPMutex{}, 10, 10, n)
+}
+
+func TestBiasedPMutexRevocation(t *testing.T) {
+	var m plock.BiasedPMutex
+	if m.Biased() {
+		t.Fatal("zero value should start unbiased")
+	}
+
+	// the first reader takes the slow path, and turns the bias on
+	m.RUnlock(m.RLock())
+	if !m.Biased() {
+		t.Fatal("bias was not turned on by a reader")
+	}
+
+	r := m.RLock()
+	if m.TryWLock() {
+		t.Fatal("TryWLock succeeded with a biased reader present")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.WLock()
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("WLock succeeded with a biased reader present")
+	default:
+	}
+	m.RUnlock(r)
+	<-done
+
+	if m.Biased() {
+		t.Fatal("WLock did not revoke the bias")
+	}
+	if _, ok := m.TryRLock(); ok {
+		t.Fatal("TryRLock succeeded while a Write Lock was held")
+	}
+	m.WUnlock()
+
+	// eventually, a slow path reader turns the bias back on
+	deadline := time.Now().Add(5 * time.Second)
+	for !m.Biased() && time.Now().Before(deadline) {
+		m.RUnlock(m.RLock())
+		time.Sleep(time.Millisecond)
+	}
+	if !m.Biased() {
+		t.Fatal("bias was never turned back on")
+	}
+}
+
+func BenchmarkBiasedPMutexWrite10000(b *testing.B) {
+	var rwm plock.BiasedPMutex
+	b.RunParallel(func(pb *testing.PB) {
+		foo := 0
+		for pb.Next() {
+			foo++
+			if foo%10000 == 0 {
+				rwm.WLock()
+				rwm.WUnlock()
+			} else {
+				rwm.RUnlock(rwm.RLock())
+			}
+		}
+	})
+}
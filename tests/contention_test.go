@@ -0,0 +1,136 @@
+package plock_test
+
+import (
+	"bytes"
+	"regexp"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// contendedSamples returns the total delay and count of the samples in the
+// contention profile whose stack includes fn
+func contendedSamples(t *testing.T, fn string) (delay time.Duration, count int) {
+	buf := &bytes.Buffer{}
+	if err := plock.WriteContentionProfile(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "--- contention:\ncycles/second=1000000000\n") {
+		t.Fatalf("unexpected header:\n%s", buf)
+	}
+
+	sample := regexp.MustCompile(`^(\d+) (\d+) @`)
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		lines := strings.Split(record, "\n")
+		for len(lines) > 0 && !sample.MatchString(lines[0]) {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 || !strings.Contains(record, fn+"+") {
+			continue
+		}
+		m := sample.FindStringSubmatch(lines[0])
+		d, _ := strconv.ParseInt(m[1], 10, 64)
+		c, _ := strconv.Atoi(m[2])
+		delay += time.Duration(d)
+		count += c
+	}
+
+	return delay, count
+}
+
+// contendedStack returns the functions of the first stack in the contention
+// profile that includes fn, from the top
+func contendedStack(t *testing.T, fn string) []string {
+	buf := &bytes.Buffer{}
+	if err := plock.WriteContentionProfile(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := regexp.MustCompile(`(?m)^#\t\S+\t(\S+)\+`)
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		if !strings.Contains(record, fn+"+") {
+			continue
+		}
+		var stack []string
+		for _, m := range frame.FindAllStringSubmatch(record, -1) {
+			stack = append(stack, m[1])
+		}
+		return stack
+	}
+
+	return nil
+}
+
+func TestContentionProfile(t *testing.T) {
+	defer plock.SetContentionProfileRate(plock.SetContentionProfileRate(1))
+	defer plock.ResetContentionProfile()
+	plock.ResetContentionProfile()
+
+	m := &plock.PMutex{}
+	m.RLock()
+	m.RUnlock()
+
+	m.WLock()
+	done := make(chan struct{})
+	go func() {
+		m.RLock()
+		m.RUnlock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	m.WUnlock()
+	<-done
+
+	m.SLock()
+	m.RLock()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		m.RUnlock()
+	}()
+	m.SToW()
+	m.WUnlock()
+
+	if d, n := contendedSamples(t, "(*PMutex).RLock"); n != 1 || d < 10*time.Millisecond {
+		t.Fatalf("RLock: %d samples waited %v, expected 1 of at least 10ms", n, d)
+	}
+	if d, n := contendedSamples(t, "(*PMutex).SToW"); n != 1 || d < 10*time.Millisecond {
+		t.Fatalf("SToW: %d samples waited %v, expected 1 of at least 10ms", n, d)
+	}
+	// stacks start at the exported method, called by the test
+	for _, fn := range []string{"(*PMutex).RLock", "(*PMutex).SToW"} {
+		stack := contendedStack(t, fn)
+		if len(stack) < 2 || !strings.HasSuffix(stack[0], "."+fn) || !strings.Contains(stack[1], ".TestContentionProfile") {
+			t.Fatalf("%s: unexpected stack %v", fn, stack)
+		}
+	}
+	if p := pprof.Lookup(plock.ContentionProfileName); p == nil || p.Count() != 2 {
+		t.Fatalf("runtime/pprof profile: %v", p)
+	}
+
+	plock.ResetContentionProfile()
+	if _, n := contendedSamples(t, "(*PMutex)"); n != 0 {
+		t.Fatalf("%d samples after reset", n)
+	}
+}
+
+func TestContentionProfileOff(t *testing.T) {
+	plock.ResetContentionProfile()
+	defer plock.SetContentionProfileRate(plock.SetContentionProfileRate(0))
+
+	m := &plock.PMutex{}
+	m.WLock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.WUnlock()
+	}()
+	m.WLock()
+	m.WUnlock()
+
+	if _, n := contendedSamples(t, "(*PMutex)"); n != 0 {
+		t.Fatalf("%d samples with profiling off", n)
+	}
+}
@@ -0,0 +1,152 @@
+package plock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func expireSoon() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 50*time.Millisecond)
+}
+
+func TestPMutexLockContextSucceeds(t *testing.T) {
+	m := &plock.PMutex{}
+	ctx := context.Background()
+
+	if err := m.RLockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RToSContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SToWContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.WToR()
+	if err := m.RToWContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.WToR()
+	if err := m.RToAContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.AUnlock()
+
+	if err := m.WLockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.WUnlock()
+	if err := m.SLockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.SUnlock()
+	if err := m.ALockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.AUnlock()
+
+	if !m.TryWLock() {
+		t.Fatal(m.String())
+	}
+}
+
+func TestPMutexLockContextGivesUp(t *testing.T) {
+	m := &plock.PMutex{}
+	unlocked := m.String()
+
+	m.WLock()
+	for name, f := range map[string]func(context.Context) error{
+		"RLockContext": m.RLockContext,
+		"SLockContext": m.SLockContext,
+		"WLockContext": m.WLockContext,
+		"ALockContext": m.ALockContext,
+	} {
+		ctx, cancel := expireSoon()
+		err := f(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: expected %v, got %v", name, context.DeadlineExceeded, err)
+		}
+	}
+	m.WUnlock()
+
+	if m.String() != unlocked {
+		t.Fatal(m.String())
+	}
+}
+
+func TestPMutexLockContextRollsBackWhileDraining(t *testing.T) {
+	m := &plock.PMutex{}
+	m.RLock()
+	before := m.String()
+
+	ctx, cancel := expireSoon()
+	defer cancel()
+	if err := m.WLockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if after := m.String(); before != after {
+		t.Fatalf("WLockContext left bits behind: %s -> %s", before, after)
+	}
+
+	ctx, cancel = expireSoon()
+	defer cancel()
+	if err := m.ALockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if after := m.String(); before != after {
+		t.Fatalf("ALockContext left bits behind: %s -> %s", before, after)
+	}
+
+	// new readers must not be locked out after the rollback
+	if !m.TryRLock() {
+		t.Fatal("reader could not enter after a cancelled WLockContext")
+	}
+	m.RUnlock()
+	m.RUnlock()
+}
+
+func TestPMutexUpgradeContextKeepsOriginalLock(t *testing.T) {
+	m := &plock.PMutex{}
+	m.RLock()
+	m.RLock()
+	before := m.String()
+
+	for name, f := range map[string]func(context.Context) error{
+		"RToWContext": m.RToWContext,
+		"RToAContext": m.RToAContext,
+	} {
+		ctx, cancel := expireSoon()
+		err := f(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: expected %v, got %v", name, context.DeadlineExceeded, err)
+		}
+		if after := m.String(); before != after {
+			t.Errorf("%s left bits behind: %s -> %s", name, before, after)
+		}
+	}
+
+	if err := m.RToSContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	before = m.String()
+	ctx, cancel := expireSoon()
+	defer cancel()
+	if err := m.SToWContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if after := m.String(); before != after {
+		t.Fatalf("SToWContext left bits behind: %s -> %s", before, after)
+	}
+
+	m.RUnlock()
+	m.SToW()
+	m.WUnlock()
+	if !m.TryWLock() {
+		t.Fatal(m.String())
+	}
+}
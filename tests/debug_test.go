@@ -0,0 +1,97 @@
+//go:build plock_debug
+// +build plock_debug
+
+package plock_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func expectMisuse(t *testing.T, op string, f func()) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("%s did not panic", op)
+		}
+		if msg := fmt.Sprint(r); !strings.Contains(msg, "plock: "+op+" without") {
+			t.Fatalf("%s panicked with %q", op, msg)
+		}
+	}()
+	f()
+}
+
+func TestDebugMisuse(t *testing.T) {
+	cases := []struct {
+		op    string
+		setup func(*plock.PMutex)
+		f     func(*plock.PMutex)
+	}{
+		{"RUnlock", func(m *plock.PMutex) {}, (*plock.PMutex).RUnlock},
+		{"RUnlock", (*plock.PMutex).SLock, (*plock.PMutex).RUnlock},
+		{"RUnlock", (*plock.PMutex).WLock, (*plock.PMutex).RUnlock},
+		{"WUnlock", func(m *plock.PMutex) {}, (*plock.PMutex).WUnlock},
+		{"WUnlock", (*plock.PMutex).RLock, (*plock.PMutex).WUnlock},
+		{"WUnlock", (*plock.PMutex).SLock, (*plock.PMutex).WUnlock},
+		{"WToR", (*plock.PMutex).SLock, (*plock.PMutex).WToR},
+		{"WToS", (*plock.PMutex).ALock, (*plock.PMutex).WToS},
+		{"SUnlock", (*plock.PMutex).RLock, (*plock.PMutex).SUnlock},
+		{"SUnlock", (*plock.PMutex).ALock, (*plock.PMutex).SUnlock},
+		{"SToR", (*plock.PMutex).RLock, (*plock.PMutex).SToR},
+		{"AUnlock", (*plock.PMutex).RLock, (*plock.PMutex).AUnlock},
+		{"SToW", (*plock.PMutex).RLock, (*plock.PMutex).SToW},
+		{"TrySToW", func(m *plock.PMutex) {}, func(m *plock.PMutex) { m.TrySToW() }},
+		{"RToS", func(m *plock.PMutex) {}, (*plock.PMutex).RToS},
+		{"RToS", (*plock.PMutex).SLock, (*plock.PMutex).RToS},
+		{"RToW", (*plock.PMutex).ALock, (*plock.PMutex).RToW},
+		{"RToA", func(m *plock.PMutex) {}, (*plock.PMutex).RToA},
+		{"TryRToA", func(m *plock.PMutex) {}, func(m *plock.PMutex) { m.TryRToA() }},
+		{"TryRToW", func(m *plock.PMutex) {}, func(m *plock.PMutex) { m.TryRToW() }},
+		{"TryRToS", func(m *plock.PMutex) {}, func(m *plock.PMutex) { m.TryRToS() }},
+	}
+
+	for _, c := range cases {
+		m := &plock.PMutex{}
+		c.setup(m)
+		before := m.State()
+		expectMisuse(t, c.op, func() { c.f(m) })
+		if after := m.State(); after != before {
+			t.Errorf("%s changed the lock word from %v to %v", c.op, before, after)
+		}
+	}
+}
+
+func TestDebugDoubleUnlock(t *testing.T) {
+	m := &plock.PMutex{}
+	m.RLock()
+	m.RUnlock()
+	expectMisuse(t, "RUnlock", m.RUnlock)
+
+	m.WLock()
+	m.WToS()
+	m.SToR()
+	expectMisuse(t, "WToR", m.WToR)
+	m.RUnlock()
+	expectMisuse(t, "RUnlock", m.RUnlock)
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+}
+
+func TestDebugValidUse(t *testing.T) {
+	m := &plock.PMutex{}
+
+	m.RLock()
+	m.SLock()
+	m.RUnlock()
+	m.SToW()
+	m.WToS()
+	m.RLock()
+	m.SToR()
+	m.RToS()
+	m.SUnlock()
+	m.RToA()
+	m.AUnlock()
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+}
@@ -0,0 +1,42 @@
+package plock_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestPublishDuplicate(t *testing.T) {
+	_, s := publishedLock(t, "duplicate")
+	defer s.Unpublish()
+	if err := plock.NewStats().Publish("duplicate"); err == nil {
+		t.Fatal("published the same name twice")
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	m, s := publishedLock(t, "expvar")
+	defer s.Unpublish()
+	m.RLock()
+	m.RUnlock()
+	m.SLock()
+
+	v := expvar.Get(plock.ExpvarName)
+	if v == nil {
+		t.Fatalf("%s not published", plock.ExpvarName)
+	}
+	var published map[string]plock.StatsSnapshot
+	if err := json.Unmarshal([]byte(v.String()), &published); err != nil {
+		t.Fatal(err)
+	}
+	snap, ok := published["expvar"]
+	if !ok {
+		t.Fatalf("stats not published: %s", v)
+	}
+	if snap.Read.Acquisitions != 1 || snap.Seek.Held != 1 || snap.Read.Wait.Count() != 1 {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+	m.SUnlock()
+}
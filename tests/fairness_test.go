@@ -0,0 +1,196 @@
+package plock_test
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+var fairnessPolicies = []plock.Fairness{
+	plock.Unfair,
+	plock.ReaderPreferring,
+	plock.WriterPreferring,
+	plock.FIFO,
+}
+
+func TestPMutexFairnessHammer(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	n := 1000
+	if testing.Short() {
+		n = 5
+	}
+
+	for _, f := range fairnessPolicies {
+		t.Run(f.String(), func(t *testing.T) {
+			hammerPMutex(plock.NewPMutex(plock.WithFairness(f)), 4, 3, n)
+			hammerPMutex(plock.NewPMutex(plock.WithFairness(f)), 10, 10, n)
+			hammerPMutex(plock.NewPMutex(plock.WithFairness(f), plock.WithParking()), 10, 10, n)
+		})
+	}
+}
+
+// readerStream keeps the mutex read locked by overlapping readers until stop
+// is closed
+func readerStream(m *plock.PMutex, stop chan struct{}, wg *sync.WaitGroup) {
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.RLock()
+				time.Sleep(time.Millisecond)
+				m.RUnlock()
+			}
+		}()
+	}
+}
+
+func TestPMutexWriterNotStarvedByReaders(t *testing.T) {
+	for _, f := range []plock.Fairness{plock.WriterPreferring, plock.FIFO} {
+		t.Run(f.String(), func(t *testing.T) {
+			m := plock.NewPMutex(plock.WithFairness(f))
+			stop := make(chan struct{})
+			wg := &sync.WaitGroup{}
+			readerStream(m, stop, wg)
+			defer func() {
+				close(stop)
+				wg.Wait()
+			}()
+
+			time.Sleep(10 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for i := 0; i < 10; i++ {
+				if err := m.WLockContext(ctx); err != nil {
+					t.Fatalf("writer starved: %v", err)
+				}
+				m.WUnlock()
+			}
+		})
+	}
+}
+
+func TestPMutexReaderPreferringAdmitsReadersPastWaitingWriter(t *testing.T) {
+	m := plock.NewPMutex(plock.WithFairness(plock.ReaderPreferring))
+	m.RLock()
+
+	var wrote int32
+	go func() {
+		m.WLock()
+		atomic.StoreInt32(&wrote, 1)
+		m.WUnlock()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// under Unfair, the waiting writer would hold this reader off
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.RLockContext(ctx); err != nil {
+		t.Fatalf("reader held off by a waiting writer: %v", err)
+	}
+	if atomic.LoadInt32(&wrote) != 0 {
+		t.Fatal("writer acquired the lock while readers were present")
+	}
+	m.RUnlock()
+	m.RUnlock()
+}
+
+func TestPMutexWriterPreferringHoldsOffReaders(t *testing.T) {
+	for _, f := range []plock.Fairness{plock.Unfair, plock.WriterPreferring} {
+		t.Run(f.String(), func(t *testing.T) {
+			m := plock.NewPMutex(plock.WithFairness(f))
+			m.SLock()
+
+			// the writer can't even start draining readers while the
+			// Seek Lock is held
+			go func() {
+				m.WLock()
+				m.WUnlock()
+			}()
+			time.Sleep(50 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := m.RLockContext(ctx)
+			if f == plock.WriterPreferring && err != context.DeadlineExceeded {
+				t.Errorf("reader admitted while a writer was waiting: %v", err)
+			}
+			if f == plock.Unfair {
+				if err != nil {
+					t.Errorf("reader not admitted: %v", err)
+				} else {
+					m.RUnlock()
+				}
+			}
+			m.SUnlock()
+		})
+	}
+}
+
+func TestPMutexFIFOOrder(t *testing.T) {
+	m := plock.NewPMutex(plock.WithFairness(plock.FIFO))
+	m.WLock()
+
+	n := 20
+	order := make(chan int, n)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				m.WLock()
+				order <- i
+				m.WUnlock()
+			} else {
+				m.SLock()
+				order <- i
+				m.SUnlock()
+			}
+		}(i)
+		// make sure goroutine i is queued before i+1 arrives
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	m.WUnlock()
+	wg.Wait()
+	close(order)
+
+	expected := 0
+	for i := range order {
+		if i != expected {
+			t.Fatalf("expected waiter %d to be admitted, got %d", expected, i)
+		}
+		expected++
+	}
+}
+
+func TestPMutexFIFOContextCancel(t *testing.T) {
+	m := plock.NewPMutex(plock.WithFairness(plock.FIFO))
+	m.WLock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.RLockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	m.WUnlock()
+
+	// the abandoned place in line must not block later waiters
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.WLockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.WUnlock()
+}
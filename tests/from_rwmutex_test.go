@@ -12,6 +12,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/richardsamuels/go-plock"
 )
@@ -87,19 +88,23 @@ func writer(rwm *plock.PMutex, num_iterations int, activity *int32, cdone chan b
 }
 
 func HammerPMutex(gomaxprocs, numReaders, num_iterations int) {
+	var rwm plock.PMutex
+	hammerPMutex(&rwm, gomaxprocs, numReaders, num_iterations)
+}
+
+func hammerPMutex(rwm *plock.PMutex, gomaxprocs, numReaders, num_iterations int) {
 	runtime.GOMAXPROCS(gomaxprocs)
 	// Number of active readers + 10000 * number of active writers.
 	var activity int32
-	var rwm plock.PMutex
 	cdone := make(chan bool)
-	go writer(&rwm, num_iterations, &activity, cdone)
+	go writer(rwm, num_iterations, &activity, cdone)
 	var i int
 	for i = 0; i < numReaders/2; i++ {
-		go reader(&rwm, num_iterations, &activity, cdone)
+		go reader(rwm, num_iterations, &activity, cdone)
 	}
-	go writer(&rwm, num_iterations, &activity, cdone)
+	go writer(rwm, num_iterations, &activity, cdone)
 	for ; i < numReaders; i++ {
-		go reader(&rwm, num_iterations, &activity, cdone)
+		go reader(rwm, num_iterations, &activity, cdone)
 	}
 	// Wait for the 2 writers and all readers to finish.
 	for i := 0; i < 2+numReaders; i++ {
@@ -180,6 +185,10 @@ func BenchmarkPMutexUncontended(b *testing.B) {
 
 func benchmarkPMutex(b *testing.B, localWork, writeRatio int) {
 	var rwm plock.PMutex
+	benchmarkPMutexWith(b, &rwm, localWork, writeRatio)
+}
+
+func benchmarkPMutexWith(b *testing.B, rwm *plock.PMutex, localWork, writeRatio int) {
 	b.RunParallel(func(pb *testing.PB) {
 		foo := 0
 		for pb.Next() {
@@ -215,3 +224,39 @@ func BenchmarkPMutexWorkWrite100(b *testing.B) {
 func BenchmarkPMutexWorkWrite10(b *testing.B) {
 	benchmarkPMutex(b, 100, 10)
 }
+
+var waitStrategies = []struct {
+	name string
+	new  func() plock.WaitStrategy
+}{
+	{"Yield", plock.Yield},
+	{"SpinThenYield", func() plock.WaitStrategy { return plock.SpinThenYield(100) }},
+	{"Backoff", func() plock.WaitStrategy { return plock.Backoff(time.Microsecond, time.Millisecond) }},
+	{"Sleep", func() plock.WaitStrategy { return plock.Sleep(10 * time.Microsecond) }},
+	{"Park", func() plock.WaitStrategy { return plock.Park(time.Millisecond) }},
+}
+
+func benchmarkPMutexStrategies(b *testing.B, localWork, writeRatio int) {
+	for _, s := range waitStrategies {
+		b.Run(s.name, func(b *testing.B) {
+			rwm := plock.NewPMutex(plock.WithWaitStrategy(s.new()))
+			benchmarkPMutexWith(b, rwm, localWork, writeRatio)
+		})
+	}
+}
+
+func BenchmarkPMutexStrategyWrite100(b *testing.B) {
+	benchmarkPMutexStrategies(b, 0, 100)
+}
+
+func BenchmarkPMutexStrategyWrite10(b *testing.B) {
+	benchmarkPMutexStrategies(b, 0, 10)
+}
+
+func BenchmarkPMutexStrategyWorkWrite100(b *testing.B) {
+	benchmarkPMutexStrategies(b, 100, 100)
+}
+
+func BenchmarkPMutexStrategyWorkWrite10(b *testing.B) {
+	benchmarkPMutexStrategies(b, 100, 10)
+}
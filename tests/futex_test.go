@@ -0,0 +1,97 @@
+package plock_test
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestPMutexFutex(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	n := 1000
+	if testing.Short() {
+		n = 5
+	}
+
+	hammerPMutex(plock.NewPMutex(plock.WithFutex()), 1, 3, n)
+	hammerPMutex(plock.NewPMutex(plock.WithFutex()), 4, 3, n)
+	hammerPMutex(plock.NewPMutex(plock.WithFutex()), 10, 10, n)
+}
+
+func TestPMutexFutexWakes(t *testing.T) {
+	unlocks := []struct {
+		name          string
+		lock, unlock  func(*plock.PMutex)
+		waiter        func(*plock.PMutex)
+		waiterRelease func(*plock.PMutex)
+	}{
+		{"WUnlock", (*plock.PMutex).WLock, (*plock.PMutex).WUnlock, (*plock.PMutex).RLock, (*plock.PMutex).RUnlock},
+		{"SUnlock", (*plock.PMutex).SLock, (*plock.PMutex).SUnlock, (*plock.PMutex).WLock, (*plock.PMutex).WUnlock},
+		{"AUnlock", (*plock.PMutex).ALock, (*plock.PMutex).AUnlock, (*plock.PMutex).SLock, (*plock.PMutex).SUnlock},
+		{"RUnlock", (*plock.PMutex).RLock, (*plock.PMutex).RUnlock, (*plock.PMutex).ALock, (*plock.PMutex).AUnlock},
+		{"WToR", (*plock.PMutex).WLock, func(m *plock.PMutex) { m.WToR(); m.RUnlock() }, (*plock.PMutex).RLock, (*plock.PMutex).RUnlock},
+	}
+
+	for _, u := range unlocks {
+		t.Run(u.name, func(t *testing.T) {
+			m := plock.NewPMutex(plock.WithFutex())
+			u.lock(m)
+
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				u.waiter(m)
+				u.waiterRelease(m)
+				wg.Done()
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+			if s := m.State(); s.Word&1 == 0 {
+				t.Fatalf("waiter is not sleeping: %v", s)
+			}
+			u.unlock(m)
+			waitOrFail(t, wg, u.name)
+
+			if s := m.State(); s.Mode != plock.Unlocked {
+				t.Fatalf("lock left in %v", s)
+			}
+		})
+	}
+}
+
+func TestPMutexFutexContext(t *testing.T) {
+	m := plock.NewPMutex(plock.WithFutex())
+	m.WLock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.RLockContext(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("RLockContext = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled waiter kept sleeping")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.SLockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("SLockContext = %v, want context.DeadlineExceeded", err)
+	}
+
+	m.WUnlock()
+	if s := m.State(); s.Mode != plock.Unlocked {
+		t.Fatalf("lock left in %v", s)
+	}
+}
@@ -0,0 +1,103 @@
+package plock_test
+
+import (
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestGuardTransitions(t *testing.T) {
+	m := &plock.PMutex{}
+
+	r := m.RGuard()
+	expectState(t, m, plock.Read, 1, false, 0)
+	s := r.UpgradeToSeek()
+	expectState(t, m, plock.Seek, 1, true, 0)
+	w := s.Upgrade()
+	expectState(t, m, plock.Write, 1, true, 1)
+	s = w.DowngradeToSeek()
+	expectState(t, m, plock.Seek, 1, true, 0)
+	r = s.Downgrade()
+	expectState(t, m, plock.Read, 1, false, 0)
+	w = r.UpgradeToWrite()
+	expectState(t, m, plock.Write, 1, true, 1)
+	r = w.Downgrade()
+	a := r.UpgradeToAtomic()
+	expectState(t, m, plock.Atomic, 0, false, 1)
+	a.Release()
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+
+	m.WGuard().Release()
+	m.SGuard().Release()
+	m.AGuard().Release()
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+}
+
+func TestGuardTry(t *testing.T) {
+	m := &plock.PMutex{}
+
+	s, ok := m.TrySGuard()
+	if !ok {
+		t.Fatal("TrySGuard failed on an unlocked PMutex")
+	}
+	if _, ok = m.TrySGuard(); ok {
+		t.Fatal("TrySGuard succeeded with a seeker")
+	}
+	if _, ok = m.TryWGuard(); ok {
+		t.Fatal("TryWGuard succeeded with a seeker")
+	}
+	if _, ok = m.TryAGuard(); ok {
+		t.Fatal("TryAGuard succeeded with a seeker")
+	}
+
+	r, ok := m.TryRGuard()
+	if !ok {
+		t.Fatal("TryRGuard failed alongside a seeker")
+	}
+	if _, ok = s.TryUpgrade(); ok {
+		t.Fatal("TryUpgrade succeeded with another reader")
+	}
+	if _, ok = r.TryUpgradeToSeek(); ok {
+		t.Fatal("TryUpgradeToSeek succeeded with a seeker")
+	}
+	if _, ok = r.TryUpgradeToWrite(); ok {
+		t.Fatal("TryUpgradeToWrite succeeded with a seeker")
+	}
+	if _, ok = r.TryUpgradeToAtomic(); ok {
+		t.Fatal("TryUpgradeToAtomic succeeded with a seeker")
+	}
+	expectState(t, m, plock.Seek, 2, true, 0)
+
+	s.Release()
+	a, ok := r.TryUpgradeToAtomic()
+	if !ok {
+		t.Fatal("TryUpgradeToAtomic failed as the only reader")
+	}
+	a.Release()
+
+	a, ok = m.TryAGuard()
+	if !ok {
+		t.Fatal("TryAGuard failed on an unlocked PMutex")
+	}
+	if _, ok = m.TryRGuard(); ok {
+		t.Fatal("TryRGuard succeeded with an atomic writer")
+	}
+	a.Release()
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+}
+
+func TestGuardAllocs(t *testing.T) {
+	m := &plock.PMutex{}
+	allocs := testing.AllocsPerRun(100, func() {
+		r := m.RGuard()
+		s := r.UpgradeToSeek()
+		w := s.Upgrade()
+		w.Downgrade().Release()
+		if a, ok := m.TryAGuard(); ok {
+			a.Release()
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("guards allocated %v times per run", allocs)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build go1.18
+// +build go1.18
+
+package plock_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestGuardedZeroValue(t *testing.T) {
+	var g plock.Guarded[map[string]int]
+
+	g.Write(func(v *map[string]int) {
+		*v = map[string]int{"a": 1}
+	})
+	g.Read(func(v *map[string]int) {
+		if (*v)["a"] != 1 {
+			t.Errorf("expected 1, got %d", (*v)["a"])
+		}
+	})
+}
+
+func TestGuardedSeekUpgradesOnlyWhenNeeded(t *testing.T) {
+	g := plock.NewGuarded(0)
+
+	n := 100
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Seek(func(v *int, upgrade func()) {
+				if *v%2 == 0 {
+					upgrade()
+					upgrade()
+				}
+				*v++
+			})
+		}()
+	}
+	wg.Wait()
+
+	g.Read(func(v *int) {
+		if *v != n {
+			t.Errorf("expected %d, got %d", n, *v)
+		}
+	})
+}
+
+func TestGuardedAtomic(t *testing.T) {
+	g := plock.NewGuarded(int32(0), plock.WithParking())
+
+	n := 100
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Atomic(func(v *int32) {
+				atomic.AddInt32(v, 1)
+			})
+		}()
+	}
+	wg.Wait()
+
+	g.Read(func(v *int32) {
+		if *v != int32(n) {
+			t.Errorf("expected %d, got %d", n, *v)
+		}
+	})
+}
+
+func TestGuardedReleasesOnPanic(t *testing.T) {
+	g := plock.NewGuarded("")
+
+	mustPanic := func(f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		f()
+	}
+
+	mustPanic(func() { g.Read(func(*string) { panic("read") }) })
+	mustPanic(func() { g.Write(func(*string) { panic("write") }) })
+	mustPanic(func() { g.Atomic(func(*string) { panic("atomic") }) })
+	mustPanic(func() { g.Seek(func(*string, func()) { panic("seek") }) })
+	mustPanic(func() {
+		g.Seek(func(_ *string, upgrade func()) {
+			upgrade()
+			panic("upgraded seek")
+		})
+	})
+
+	// every lock must have been released
+	g.Write(func(v *string) { *v = "ok" })
+}
+
+func TestGuardedUnregister(t *testing.T) {
+	g := plock.NewGuarded(0, plock.Named("guarded"))
+	if n := len(registered("guarded")); n != 1 {
+		t.Fatalf("%d locks registered, expected 1", n)
+	}
+	g.Unregister()
+	if n := len(registered("guarded")); n != 0 {
+		t.Fatalf("%d locks registered after Unregister, expected 0", n)
+	}
+}
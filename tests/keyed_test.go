@@ -0,0 +1,108 @@
+//go:build go1.18
+// +build go1.18
+
+package plock_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestKeyedLocksDropsUnusedKeys(t *testing.T) {
+	var k plock.KeyedLocks[string]
+
+	k.RLock("a")
+	k.RLock("a")
+	k.WLock("b")
+	if k.Len() != 2 {
+		t.Fatalf("expected 2 keys, got %d", k.Len())
+	}
+
+	k.RUnlock("a")
+	if k.Len() != 2 {
+		t.Fatal("key dropped while still read locked")
+	}
+	k.RUnlock("a")
+	k.WToR("b")
+	k.RToS("b")
+	k.SToW("b")
+	k.WUnlock("b")
+	if k.Len() != 0 {
+		t.Fatalf("expected no keys, got %d", k.Len())
+	}
+}
+
+func TestKeyedLocksExclusion(t *testing.T) {
+	k := plock.NewKeyedLocks[int](plock.WithParking())
+	n := 200
+	if testing.Short() {
+		n = 20
+	}
+
+	counts := make([]int, 4)
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			key := g % len(counts)
+			for i := 0; i < n; i++ {
+				if i%2 == 0 {
+					k.WLock(key)
+					counts[key]++
+					k.WUnlock(key)
+				} else {
+					k.SLock(key)
+					k.SToW(key)
+					counts[key]++
+					k.WToS(key)
+					k.SUnlock(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for key, c := range counts {
+		if c != 4*n {
+			t.Errorf("key %d: expected %d, got %d", key, 4*n, c)
+		}
+	}
+	if k.Len() != 0 {
+		t.Fatalf("expected no keys, got %d", k.Len())
+	}
+}
+
+func TestKeyedLocksKeepsKeyForWaiters(t *testing.T) {
+	var k plock.KeyedLocks[string]
+	k.WLock("a")
+
+	done := make(chan struct{})
+	go func() {
+		k.RLock("a")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the waiting reader must find the same lock once the writer releases it
+	k.WUnlock("a")
+	<-done
+	if k.Len() != 1 {
+		t.Fatalf("expected 1 key, got %d", k.Len())
+	}
+	k.RUnlock("a")
+}
+
+func TestKeyedLocksUnlockOfUnlockedKeyPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	var k plock.KeyedLocks[string]
+	k.RUnlock("a")
+}
@@ -0,0 +1,217 @@
+package plock_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// captureViolations collects the lock order violations reported until the
+// returned function is called
+func captureViolations() (get func() []plock.LockOrderViolation, stop func()) {
+	var mu sync.Mutex
+	var found []plock.LockOrderViolation
+	plock.SetLockOrderReporter(func(v plock.LockOrderViolation) {
+		mu.Lock()
+		found = append(found, v)
+		mu.Unlock()
+	})
+
+	get = func() []plock.LockOrderViolation {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]plock.LockOrderViolation(nil), found...)
+	}
+	return get, func() { plock.SetLockOrderReporter(nil) }
+}
+
+func TestLockOrderInversion(t *testing.T) {
+	found, stop := captureViolations()
+	defer stop()
+
+	a := plock.NewPMutex(plock.WithLockOrder(), plock.WithName("a"))
+	b := plock.NewPMutex(plock.WithLockOrder(), plock.WithName("b"))
+
+	for i := 0; i < 2; i++ {
+		a.WLock()
+		b.WLock()
+		b.WUnlock()
+		a.WUnlock()
+
+		b.WLock()
+		a.WLock()
+		a.WUnlock()
+		b.WUnlock()
+	}
+
+	v := found()
+	if len(v) != 1 {
+		t.Fatalf("expected the inversion to be reported once, got %v", v)
+	}
+	cycle := v[0].Cycle
+	if len(cycle) != 2 || cycle[0].Held != "b" || cycle[0].Wanted != "a" || cycle[1].Held != "a" || cycle[1].Wanted != "b" {
+		t.Fatalf("unexpected cycle: %v", v[0])
+	}
+	for _, e := range cycle {
+		if !strings.Contains(e.HeldStack, "TestLockOrderInversion") || !strings.Contains(e.WantedStack, "TestLockOrderInversion") {
+			t.Fatalf("missing stacks: %v", v[0])
+		}
+	}
+}
+
+func TestLockOrderModes(t *testing.T) {
+	found, stop := captureViolations()
+	defer stop()
+
+	newPair := func() (*plock.PMutex, *plock.PMutex) {
+		return plock.NewPMutex(plock.WithLockOrder()), plock.NewPMutex(plock.WithLockOrder())
+	}
+
+	// readers never wait for each other
+	a, b := newPair()
+	a.RLock()
+	b.RLock()
+	b.RUnlock()
+	a.RUnlock()
+	b.RLock()
+	a.RLock()
+	a.RUnlock()
+	b.RUnlock()
+
+	// nor do a reader and a seeker
+	a.SLock()
+	b.RLock()
+	b.RUnlock()
+	a.SUnlock()
+	b.RLock()
+	a.RLock()
+	a.RUnlock()
+	b.RUnlock()
+
+	// Try methods never wait
+	a, b = newPair()
+	a.WLock()
+	b.TryWLock()
+	b.WUnlock()
+	a.WUnlock()
+	b.WLock()
+	a.TryWLock()
+	a.WUnlock()
+	b.WUnlock()
+
+	if v := found(); len(v) != 0 {
+		t.Fatalf("reported cycles that can't deadlock: %v", v)
+	}
+
+	// a reader waiting on a writer that waits on the reader
+	a, b = newPair()
+	a.RLock()
+	b.WLock()
+	b.WUnlock()
+	a.RUnlock()
+	b.RLock()
+	a.WLock()
+	a.WUnlock()
+	b.RUnlock()
+
+	if v := found(); len(v) != 1 {
+		t.Fatalf("expected a reader/writer cycle, got %v", v)
+	}
+}
+
+func TestLockOrderUpgrade(t *testing.T) {
+	found, stop := captureViolations()
+	defer stop()
+
+	a := plock.NewPMutex(plock.WithLockOrder(), plock.WithName("a"))
+	b := plock.NewPMutex(plock.WithLockOrder(), plock.WithName("b"))
+
+	a.SLock()
+	b.WLock()
+	b.WUnlock()
+	a.SUnlock()
+
+	// reading a while holding b can't deadlock, but upgrading it can
+	b.WLock()
+	a.RLock()
+	if v := found(); len(v) != 0 {
+		t.Fatalf("read lock reported: %v", v)
+	}
+	a.RToW()
+	a.WUnlock()
+	b.WUnlock()
+
+	v := found()
+	if len(v) != 1 || v[0].Cycle[0].Held != "b" || v[0].Cycle[0].Wanted != "a" || v[0].Cycle[0].WantedMode != plock.Write {
+		t.Fatalf("expected the upgrade to be reported, got %v", v)
+	}
+}
+
+func TestLockOrderSelfDeadlock(t *testing.T) {
+	found, stop := captureViolations()
+	defer stop()
+
+	a := plock.NewPMutex(plock.WithLockOrder())
+	a.RLock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.WLockContext(ctx); err == nil {
+		t.Fatal("WLockContext succeeded while holding a Read Lock")
+	}
+	a.RUnlock()
+
+	if v := found(); len(v) != 1 || len(v[0].Cycle) != 1 {
+		t.Fatalf("expected the recursive lock to be reported, got %v", v)
+	}
+}
+
+func TestLockOrderConcurrentUpgrade(t *testing.T) {
+	found, stop := captureViolations()
+	defer stop()
+
+	a := plock.NewPMutex(plock.WithLockOrder(), plock.WithName("a"))
+
+	// a single reader upgrading is fine
+	a.RLock()
+	a.RToW()
+	a.WUnlock()
+	if v := found(); len(v) != 0 {
+		t.Fatalf("single upgrade reported: %v", v)
+	}
+
+	var read, wg sync.WaitGroup
+	read.Add(2)
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+
+			a.RLock()
+			read.Done()
+			read.Wait()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := a.RToWContext(ctx); err != nil {
+				a.RUnlock()
+				return
+			}
+			a.WUnlock()
+		}()
+	}
+	wg.Wait()
+
+	v := found()
+	if len(v) != 1 || len(v[0].Cycle) != 2 {
+		t.Fatalf("expected the concurrent upgrades to be reported, got %v", v)
+	}
+	for _, e := range v[0].Cycle {
+		if e.Held != "a" || e.HeldMode != plock.Read || e.Wanted != "a" || e.WantedMode != plock.Write {
+			t.Fatalf("unexpected cycle: %v", v[0])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package plock_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// panicMessage returns what f panics with, or "" if it doesn't
+func panicMessage(f func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	f()
+
+	return ""
+}
+
+// inGoroutine runs f in a new goroutine and waits for it
+func inGoroutine(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+}
+
+func expectHolders(t *testing.T, m *plock.PMutex, modes ...plock.Mode) {
+	holders := m.Holders()
+	if len(holders) != len(modes) {
+		t.Fatalf("expected %d holders, got %v", len(modes), holders)
+	}
+	for i, h := range holders {
+		if h.Mode != modes[i] {
+			t.Fatalf("holder %d is in %v, expected %v", i, h.Mode, modes[i])
+		}
+	}
+}
+
+func TestOwnershipHolders(t *testing.T) {
+	m := plock.NewPMutex(plock.WithOwnership())
+	expectHolders(t, m)
+
+	m.RLock()
+	expectHolders(t, m, plock.Read)
+	h := m.Holders()[0]
+	if !strings.Contains(h.Stack, "TestOwnershipHolders") || strings.Contains(h.Stack, "go-plock.(*PMutex)") {
+		t.Fatalf("stack should start at the caller:\n%s", h.Stack)
+	}
+
+	m.RToS()
+	expectHolders(t, m, plock.Seek)
+	if !m.TrySToW() {
+		t.Fatal("TrySToW failed as the only reader")
+	}
+	expectHolders(t, m, plock.Write)
+	m.WToR()
+	expectHolders(t, m, plock.Read)
+
+	// a Read Lock may be released by another goroutine
+	inGoroutine(m.RUnlock)
+	expectHolders(t, m)
+
+	m.ALock()
+	inGoroutine(m.ALock)
+	expectHolders(t, m, plock.Atomic, plock.Atomic)
+	if m.Holders()[0].Goroutine == m.Holders()[1].Goroutine {
+		t.Fatal("both atomic writers attributed to one goroutine")
+	}
+	m.AUnlock()
+	m.AUnlock()
+	expectHolders(t, m)
+
+	if plock.NewPMutex().Holders() != nil {
+		t.Fatal("Holders without WithOwnership")
+	}
+}
+
+func TestOwnershipAssertions(t *testing.T) {
+	m := plock.NewPMutex(plock.WithOwnership())
+	m.AssertNotHeld()
+
+	m.WLock()
+	m.AssertWLocked()
+	m.AssertRLocked()
+	if msg := panicMessage(m.AssertNotHeld); !strings.Contains(msg, "AssertNotHeld failed") {
+		t.Fatalf("AssertNotHeld with the Write Lock held: %q", msg)
+	}
+	if msg := panicMessage(m.AssertSLocked); !strings.Contains(msg, "AssertSLocked failed") {
+		t.Fatalf("AssertSLocked with the Write Lock held: %q", msg)
+	}
+
+	var msg string
+	inGoroutine(func() {
+		m.AssertNotHeld()
+		msg = panicMessage(m.AssertWLocked)
+	})
+	if !strings.Contains(msg, "AssertWLocked failed") || !strings.Contains(msg, "holds Write") ||
+		!strings.Contains(msg, "TestOwnershipAssertions") {
+		t.Fatalf("AssertWLocked from another goroutine should report the holder: %q", msg)
+	}
+
+	m.WToS()
+	m.AssertSLocked()
+	m.SUnlock()
+	m.AssertNotHeld()
+	if msg := panicMessage(m.AssertRLocked); msg == "" {
+		t.Fatal("AssertRLocked passed on an unlocked PMutex")
+	}
+}
+
+func TestAssertionsWithoutOwnership(t *testing.T) {
+	m := &plock.PMutex{}
+	if msg := panicMessage(m.AssertWLocked); !strings.Contains(msg, "lock is U") {
+		t.Fatalf("AssertWLocked on an unlocked PMutex: %q", msg)
+	}
+
+	m.ALock()
+	m.AssertALocked()
+	m.AssertNotHeld()
+	if msg := panicMessage(m.AssertRLocked); msg == "" {
+		t.Fatal("AssertRLocked passed with only an atomic writer")
+	}
+	m.AUnlock()
+}
@@ -0,0 +1,100 @@
+package plock_test
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestPMutexParking(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	n := 1000
+	if testing.Short() {
+		n = 5
+	}
+
+	hammerPMutex(plock.NewPMutex(plock.WithParking()), 1, 3, n)
+	hammerPMutex(plock.NewPMutex(plock.WithParking()), 4, 3, n)
+	hammerPMutex(plock.NewPMutex(plock.WithParking()), 10, 10, n)
+}
+
+func waitOrFail(t *testing.T, wg *sync.WaitGroup, what string) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parked goroutines were not woken by %s", what)
+	}
+}
+
+func TestPMutexParkedReadersWokenByWUnlock(t *testing.T) {
+	m := plock.NewPMutex(plock.WithParking())
+	m.WLock()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			m.RLock()
+			m.RUnlock()
+			wg.Done()
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	m.WUnlock()
+	waitOrFail(t, wg, "WUnlock")
+}
+
+func TestPMutexParkedWriterWokenByRUnlock(t *testing.T) {
+	m := plock.NewPMutex(plock.WithParking())
+	m.RLock()
+	m.RLock()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		m.WLock()
+		m.WUnlock()
+		wg.Done()
+	}()
+	go func() {
+		m.SLock()
+		m.SToW()
+		m.WUnlock()
+		wg.Done()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	m.RUnlock()
+	m.RUnlock()
+	waitOrFail(t, wg, "RUnlock")
+}
+
+func TestPMutexParkedContextCancel(t *testing.T) {
+	m := plock.NewPMutex(plock.WithParking())
+	m.RLock()
+	before := m.String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.WLockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if after := m.String(); before != after {
+		t.Fatalf("WLockContext left bits behind: %s -> %s", before, after)
+	}
+
+	m.RUnlock()
+	if !m.TryWLock() {
+		t.Fatal(m.String())
+	}
+}
@@ -0,0 +1,48 @@
+package plock_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestStatsHandler(t *testing.T) {
+	m, s := publishedLock(t, `prom"etheus`)
+	defer s.Unpublish()
+	m.WLock()
+	m.WToR()
+	m.RUnlock()
+	if !m.TryRLock() {
+		t.Fatal("TryRLock failed on an unlocked lock")
+	}
+
+	body, header := get(t, plock.StatsHandler())
+	if ct := header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", ct)
+	}
+
+	lock := `lock="prom\"etheus"`
+	for _, line := range []string{
+		"# TYPE plock_acquisitions_total counter",
+		"plock_acquisitions_total{" + lock + `,mode="write"} 1`,
+		"plock_acquisitions_total{" + lock + `,mode="read"} 1`,
+		"plock_held{" + lock + `,mode="read"} 1`,
+		"plock_held{" + lock + `,mode="write"} 0`,
+		"plock_conversions_total{" + lock + `,conversion="w_to_r"} 1`,
+		"# TYPE plock_wait_seconds histogram",
+		"plock_wait_seconds_bucket{" + lock + `,mode="write",le="1e-06"} 1`,
+		"plock_wait_seconds_bucket{" + lock + `,mode="write",le="+Inf"} 1`,
+		"plock_wait_seconds_count{" + lock + `,mode="read"} 1`,
+		"plock_hold_seconds_count{" + lock + `,mode="write"} 1`,
+		"plock_attempts_bucket{" + lock + `,mode="read",le="1"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `plock_hold_seconds_count{`+lock+`,mode="read"}`) {
+		t.Error("hold histogram rendered for shared Read Locks")
+	}
+	m.RUnlock()
+}
@@ -0,0 +1,165 @@
+package plock_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// registered returns the registered PMutexes named name
+func registered(name string) []*plock.PMutex {
+	var locks []*plock.PMutex
+	for _, p := range plock.Registered() {
+		if p.Info().Name == name {
+			locks = append(locks, p)
+		}
+	}
+
+	return locks
+}
+
+// waitForWaiters waits until p has n waiters
+func waitForWaiters(t *testing.T, p *plock.PMutex, n int) {
+	for deadline := time.Now().Add(5 * time.Second); p.Waiters() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters, expected %d", p.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// get serves a GET request with h, returning the body and header of its
+// response
+func get(t *testing.T, h http.Handler) (string, http.Header) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+
+	return string(body), resp.Header
+}
+
+func TestRegistry(t *testing.T) {
+	a := plock.NewPMutex(plock.Named("registry"))
+	b := plock.NewPMutex(plock.Named("registry"))
+	plock.NewPMutex(plock.WithName("registry"))
+	defer b.Unregister()
+
+	if locks := registered("registry"); len(locks) != 2 || locks[0] != a || locks[1] != b {
+		t.Fatalf("registered %v, expected %v and %v in order", locks, a, b)
+	}
+	a.Unregister()
+	a.Unregister()
+	if locks := registered("registry"); len(locks) != 1 || locks[0] != b {
+		t.Fatalf("registered %v after unregistering, expected %v", locks, b)
+	}
+
+	plock.NewStriped(4, nil, plock.Named("registry-striped"))
+	if n := len(registered("registry-striped")); n != 4 {
+		t.Fatalf("%d stripes registered, expected 4", n)
+	}
+	for _, p := range registered("registry-striped") {
+		p.Unregister()
+	}
+}
+
+func TestRegistryWaiters(t *testing.T) {
+	m := plock.NewPMutex(plock.Named("registry-waiters"))
+	defer m.Unregister()
+
+	m.WLock()
+	done := make(chan struct{})
+	go func() {
+		m.RLock()
+		m.RUnlock()
+		close(done)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		canceled <- m.SLockContext(ctx)
+	}()
+	waitForWaiters(t, m, 2)
+
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatalf("SLockContext returned %v, expected %v", err, context.Canceled)
+	}
+	waitForWaiters(t, m, 1)
+	m.WUnlock()
+	<-done
+	waitForWaiters(t, m, 0)
+
+	if n := plock.NewPMutex().Waiters(); n != 0 {
+		t.Fatalf("%d waiters on an unregistered lock", n)
+	}
+}
+
+// jsonRequest serves requests that accept JSON
+type jsonRequest struct{ http.Handler }
+
+func (h jsonRequest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Header.Set("Accept", "application/json")
+	h.Handler.ServeHTTP(w, r)
+}
+
+func TestRegistryHandler(t *testing.T) {
+	m := plock.NewPMutex(plock.Named("<sessions>"), plock.WithOwnership())
+	defer m.Unregister()
+	m.RLock()
+	m.RToS()
+	defer m.SUnlock()
+
+	body, header := get(t, plock.RegistryHandler())
+	if ct := header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("content type %q", ct)
+	}
+	for _, s := range []string{"&lt;sessions&gt;", "<td>Seek</td>", "goroutine "} {
+		if !strings.Contains(body, s) {
+			t.Errorf("missing %q in:\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, "<sessions>") {
+		t.Error("lock name not escaped")
+	}
+
+	body, header = get(t, jsonRequest{plock.RegistryHandler()})
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content type %q", ct)
+	}
+	var infos []plock.LockInfo
+	if err := json.Unmarshal([]byte(body), &infos); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if info.Name != "<sessions>" {
+			continue
+		}
+		if info.State.Mode != plock.Seek || info.Description != m.State().String() {
+			t.Fatalf("unexpected state %+v", info)
+		}
+		if len(info.Holders) != 1 || info.Holders[0].Mode != plock.Seek {
+			t.Fatalf("unexpected holders %+v", info.Holders)
+		}
+		return
+	}
+	t.Fatalf("lock missing from:\n%s", body)
+}
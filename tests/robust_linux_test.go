@@ -0,0 +1,288 @@
+package plock_test
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// TestRobustPMutexHelper is run in child processes by the RobustPMutex tests.
+// It locks in the requested mode, reports it, optionally upgrades a Seek Lock
+// and then waits to be killed
+func TestRobustPMutexHelper(t *testing.T) {
+	path := os.Getenv("PLOCK_ROBUST_PATH")
+	if path == "" {
+		t.Skip("helper process only")
+	}
+
+	r, err := plock.OpenRobust(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m plock.Mode
+	if err = m.UnmarshalText([]byte(os.Getenv("PLOCK_ROBUST_MODE"))); err != nil {
+		t.Fatal(err)
+	}
+	switch m {
+	case plock.Read:
+		err = r.RLock()
+	case plock.Seek:
+		err = r.SLock()
+	case plock.Write:
+		err = r.WLock()
+	case plock.Atomic:
+		err = r.ALock()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout.WriteString("locked\n")
+	if os.Getenv("PLOCK_ROBUST_UPGRADE") != "" {
+		r.SToW()
+	}
+	time.Sleep(time.Hour)
+}
+
+// startRobustHolder starts a helper process holding the lock at path in mode
+// m. env is added to the helper's environment
+func startRobustHolder(t *testing.T, path string, m plock.Mode, env ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRobustPMutexHelper$")
+	cmd.Env = append(os.Environ(), "PLOCK_ROBUST_PATH="+path, "PLOCK_ROBUST_MODE="+m.String())
+	cmd.Env = append(cmd.Env, env...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if line != "locked\n" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("helper failed to lock %v: %q, %v", m, line, err)
+	}
+
+	return cmd
+}
+
+func TestRobustPMutex(t *testing.T) {
+	for _, m := range []plock.Mode{plock.Read, plock.Seek, plock.Write, plock.Atomic} {
+		t.Run(m.String(), func(t *testing.T) {
+			path, cleanup := tempPath(t)
+			defer cleanup()
+			r, err := plock.OpenRobust(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			cmd := startRobustHolder(t, path, m)
+			if s := r.State(); s.Mode != m {
+				t.Fatalf("helper holds %v, want %v", s, m)
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- r.WLock()
+			}()
+			select {
+			case <-done:
+				t.Fatal("write lock acquired while the holder is alive")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			cmd.Process.Kill()
+			cmd.Wait()
+
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("dead holder was not reaped")
+			}
+			dirty := m == plock.Write || m == plock.Atomic
+			if dirty && err != plock.ErrOwnerDied {
+				t.Fatalf("WLock = %v, want ErrOwnerDied", err)
+			}
+			if !dirty && err != nil {
+				t.Fatalf("WLock = %v, want nil", err)
+			}
+			if s := r.State(); s.Mode != plock.Write || s.Readers != 1 {
+				t.Fatalf("dead holder's bits remain: %v", s)
+			}
+
+			r.MarkConsistent()
+			r.WUnlock()
+			if err = r.RLock(); err != nil {
+				t.Fatalf("RLock after MarkConsistent = %v", err)
+			}
+			r.RUnlock()
+			if s := r.State(); s.Mode != plock.Unlocked {
+				t.Fatalf("lock left in %v", s)
+			}
+		})
+	}
+}
+
+func TestRobustPMutexProgressive(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	r, err := plock.OpenRobust(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// a second handle in the same process shares its slot
+	q, err := plock.OpenRobust(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = r.SLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.RLock(); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := make(chan error)
+	go func() {
+		upgraded <- r.SToW()
+	}()
+	select {
+	case <-upgraded:
+		t.Fatal("upgraded while a reader remains")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.RUnlock()
+	if err = <-upgraded; err != nil {
+		t.Fatal(err)
+	}
+	r.WUnlock()
+
+	if s := r.State(); s.Mode != plock.Unlocked {
+		t.Fatalf("lock left in %v", s)
+	}
+}
+
+// waitForWriters waits until the lock word of r counts a Write bit
+func waitForWriters(t *testing.T, r *plock.RobustPMutex) {
+	for deadline := time.Now().Add(10 * time.Second); r.State().Writers == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("helper never took the Write bit: %v", r.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRobustPMutexDrainingHolderDies(t *testing.T) {
+	for _, name := range []string{"Write", "Atomic", "SeekToWrite"} {
+		t.Run(name, func(t *testing.T) {
+			path, cleanup := tempPath(t)
+			defer cleanup()
+			r, err := plock.OpenRobust(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			// the helper blocks behind this reader, with its Write bit
+			// taken
+			if err = r.RLock(); err != nil {
+				t.Fatal(err)
+			}
+			var cmd *exec.Cmd
+			switch name {
+			case "SeekToWrite":
+				cmd = startRobustHolder(t, path, plock.Seek, "PLOCK_ROBUST_UPGRADE=1")
+			default:
+				var m plock.Mode
+				m.UnmarshalText([]byte(name))
+				cmd = exec.Command(os.Args[0], "-test.run=^TestRobustPMutexHelper$")
+				cmd.Env = append(os.Environ(), "PLOCK_ROBUST_PATH="+path, "PLOCK_ROBUST_MODE="+m.String())
+				if err = cmd.Start(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitForWriters(t, r)
+
+			cmd.Process.Kill()
+			cmd.Wait()
+			r.RUnlock()
+
+			done := make(chan error)
+			go func() {
+				done <- r.WLock()
+			}()
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("dead waiter was not reaped: %v", r.State())
+			}
+			if err != nil {
+				t.Fatalf("WLock = %v, want nil as nothing was written", err)
+			}
+			if s := r.State(); s.Mode != plock.Write || s.Readers != 1 || s.Writers != 1 {
+				t.Fatalf("dead waiter's bits remain: %v", s)
+			}
+			r.WUnlock()
+			if s := r.State(); s.Mode != plock.Unlocked {
+				t.Fatalf("lock left in %v", s)
+			}
+		})
+	}
+}
+
+func TestRobustPMutexConversions(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	r, err := plock.OpenRobust(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	expect := func(m plock.Mode, readers int) {
+		if s := r.State(); s.Mode != m || s.Readers != readers {
+			t.Fatalf("lock in %v, want %v with %d readers", s, m, readers)
+		}
+	}
+
+	if err = r.RLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.RToS(); err != nil {
+		t.Fatal(err)
+	}
+	expect(plock.Seek, 1)
+	if err = r.SToW(); err != nil {
+		t.Fatal(err)
+	}
+	r.WToS()
+	expect(plock.Seek, 1)
+	r.SToR()
+	expect(plock.Read, 1)
+
+	// another process may now take the seek lock
+	cmd := startRobustHolder(t, path, plock.Seek)
+	cmd.Process.Kill()
+	cmd.Wait()
+	r.RUnlock()
+
+	if err = r.WLock(); err != nil {
+		t.Fatal(err)
+	}
+	r.WToR()
+	expect(plock.Read, 1)
+	r.RUnlock()
+	expect(plock.Unlocked, 0)
+}
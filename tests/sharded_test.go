@@ -0,0 +1,199 @@
+package plock_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// tokenPMutex is implemented by the locks whose Read Locks are released
+// with the RToken they were acquired with
+type tokenPMutex interface {
+	RLock() plock.RToken
+	RUnlock(plock.RToken)
+	WLock()
+	SLock()
+	SToW()
+	WToR() plock.RToken
+}
+
+func tokenReader(m tokenPMutex, num_iterations int, activity *int32, cdone chan bool) {
+	for i := 0; i < num_iterations; i++ {
+		t := m.RLock()
+		n := atomic.AddInt32(activity, 1)
+		if n < 1 || n >= 10000 {
+			panic(fmt.Sprintf("rlock(%d)\n", n))
+		}
+		atomic.AddInt32(activity, -1)
+		m.RUnlock(t)
+	}
+	cdone <- true
+}
+
+func tokenWriter(m tokenPMutex, num_iterations int, activity *int32, cdone chan bool) {
+	for i := 0; i < num_iterations; i++ {
+		if i%2 == 0 {
+			m.WLock()
+		} else {
+			m.SLock()
+			m.SToW()
+		}
+		n := atomic.AddInt32(activity, 10000)
+		if n != 10000 {
+			panic(fmt.Sprintf("wlock(%d)\n", n))
+		}
+		atomic.AddInt32(activity, -10000)
+		m.RUnlock(m.WToR())
+	}
+	cdone <- true
+}
+
+func hammerTokenPMutex(m tokenPMutex, gomaxprocs, numReaders, num_iterations int) {
+	runtime.GOMAXPROCS(gomaxprocs)
+	var activity int32
+	cdone := make(chan bool)
+	go tokenWriter(m, num_iterations, &activity, cdone)
+	var i int
+	for i = 0; i < numReaders/2; i++ {
+		go tokenReader(m, num_iterations, &activity, cdone)
+	}
+	go tokenWriter(m, num_iterations, &activity, cdone)
+	for ; i < numReaders; i++ {
+		go tokenReader(m, num_iterations, &activity, cdone)
+	}
+	for i := 0; i < 2+numReaders; i++ {
+		<-cdone
+	}
+}
+
+func TestShardedPMutex(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	n := 1000
+	if testing.Short() {
+		n = 5
+	}
+
+	hammerTokenPMutex(&plock.ShardedPMutex{}, 4, 3, n)
+	hammerTokenPMutex(plock.NewShardedPMutex(0), 1, 3, n)
+	hammerTokenPMutex(plock.NewShardedPMutex(0), 4, 10, n)
+	hammerTokenPMutex(plock.NewShardedPMutex(16), 10, 10, n)
+}
+
+func TestShardedPMutexTransitions(t *testing.T) {
+	m := plock.NewShardedPMutex(4)
+
+	r1 := m.RLock()
+	r2 := m.RLock()
+	if m.TryWLock() {
+		t.Fatal("TryWLock succeeded with readers present")
+	}
+	m.RToS(r1)
+	if m.TrySLock() {
+		t.Fatal("TrySLock succeeded while a Seek Lock was held")
+	}
+	r3, ok := m.TryRLock()
+	if !ok {
+		t.Fatal("TryRLock failed while only a Seek Lock was held")
+	}
+	m.RUnlock(r3)
+
+	done := make(chan struct{})
+	go func() {
+		m.SToW()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("SToW succeeded with readers present")
+	case <-time.After(20 * time.Millisecond):
+	}
+	m.RUnlock(r2)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SToW still blocked after the last reader left")
+	}
+
+	if _, ok := m.TryRLock(); ok {
+		t.Fatal("TryRLock succeeded while a Write Lock was held")
+	}
+	m.WToS()
+	r := m.SToR()
+	m.RUnlock(r)
+
+	if !m.TryWLock() {
+		t.Fatal("TryWLock failed on an unlocked mutex")
+	}
+	m.WUnlock()
+	m.ALock()
+	m.AUnlock()
+}
+
+func BenchmarkShardedPMutexUncontended(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		rwm := plock.NewShardedPMutex(0)
+		for pb.Next() {
+			t1 := rwm.RLock()
+			t2 := rwm.RLock()
+			rwm.RUnlock(t2)
+			rwm.RUnlock(t1)
+			rwm.WLock()
+			rwm.WUnlock()
+		}
+	})
+}
+
+func benchmarkShardedPMutex(b *testing.B, localWork, writeRatio int) {
+	rwm := plock.NewShardedPMutex(0)
+	b.RunParallel(func(pb *testing.PB) {
+		foo := 0
+		for pb.Next() {
+			foo++
+			if foo%writeRatio == 0 {
+				rwm.WLock()
+				rwm.WUnlock()
+			} else {
+				t := rwm.RLock()
+				for i := 0; i != localWork; i += 1 {
+					foo *= 2
+					foo /= 2
+				}
+				rwm.RUnlock(t)
+			}
+		}
+		_ = foo
+	})
+}
+
+func BenchmarkShardedPMutexWrite100(b *testing.B) {
+	benchmarkShardedPMutex(b, 0, 100)
+}
+
+func BenchmarkShardedPMutexWrite10(b *testing.B) {
+	benchmarkShardedPMutex(b, 0, 10)
+}
+
+func BenchmarkShardedPMutexWorkWrite100(b *testing.B) {
+	benchmarkShardedPMutex(b, 100, 100)
+}
+
+func BenchmarkShardedPMutexWorkWrite10(b *testing.B) {
+	benchmarkShardedPMutex(b, 100, 10)
+}
+
+// read-mostly comparisons, where sharding is meant to pay off
+func BenchmarkShardedPMutexWrite10000(b *testing.B) {
+	benchmarkShardedPMutex(b, 0, 10000)
+}
+
+func BenchmarkPMutexWrite10000(b *testing.B) {
+	benchmarkPMutex(b, 0, 10000)
+}
+
+func BenchmarkRWMutexWrite10000(b *testing.B) {
+	benchmarkRWMutex(b, 0, 10000)
+}
@@ -0,0 +1,240 @@
+package plock_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// The shared file holds the lock word at offset 0, followed by a pair of
+// counters that writers increment one at a time
+const (
+	sharedLockOffset = 0
+	sharedDataOffset = 64
+	sharedFileSize   = 4096
+)
+
+func sharedData(t *testing.T, path string) ([]byte, *[2]uint64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mem, err := syscall.Mmap(int(f.Fd()), 0, sharedFileSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mem, (*[2]uint64)(unsafe.Pointer(&mem[sharedDataOffset]))
+}
+
+// TestSharedPMutexHelper is run in child processes by TestSharedPMutex
+func TestSharedPMutexHelper(t *testing.T) {
+	path := os.Getenv("PLOCK_SHARED_PATH")
+	if path == "" {
+		t.Skip("helper process only")
+	}
+	n, _ := strconv.Atoi(os.Getenv("PLOCK_SHARED_N"))
+	var opts []plock.Option
+	if os.Getenv("PLOCK_SHARED_FUTEX") != "" {
+		opts = append(opts, plock.WithFutex())
+	}
+
+	p, err := plock.OpenShared(path, sharedLockOffset, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	mem, data := sharedData(t, path)
+	defer syscall.Munmap(mem)
+
+	for i := 0; i < n; i++ {
+		switch i % 3 {
+		case 0:
+			p.WLock()
+			data[0]++
+			runtime.Gosched()
+			data[1]++
+			p.WUnlock()
+		case 1:
+			p.SLock()
+			a := data[0]
+			runtime.Gosched()
+			if a != data[1] {
+				t.Fatalf("seek lock observed a torn write: %d != %d", a, data[1])
+			}
+			p.SToW()
+			data[0]++
+			runtime.Gosched()
+			data[1]++
+			p.WUnlock()
+		case 2:
+			p.RLock()
+			a := data[0]
+			runtime.Gosched()
+			if a != data[1] {
+				t.Fatalf("read lock observed a torn write: %d != %d", a, data[1])
+			}
+			p.RUnlock()
+		}
+	}
+}
+
+func TestSharedPMutex(t *testing.T) {
+	t.Run("Yield", func(t *testing.T) {
+		testSharedPMutex(t, "")
+	})
+	t.Run("Futex", func(t *testing.T) {
+		testSharedPMutex(t, "1")
+	})
+}
+
+func testSharedPMutex(t *testing.T, futex string) {
+	procs, n := 4, 3000
+	if testing.Short() {
+		n = 300
+	}
+
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(path, make([]byte, sharedFileSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := make([]*exec.Cmd, procs)
+	outs := make([]bytes.Buffer, procs)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^TestSharedPMutexHelper$")
+		cmds[i].Env = append(os.Environ(), "PLOCK_SHARED_PATH="+path, "PLOCK_SHARED_N="+strconv.Itoa(n), "PLOCK_SHARED_FUTEX="+futex)
+		cmds[i].Stdout = &outs[i]
+		cmds[i].Stderr = &outs[i]
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("helper failed: %v\n%s", err, outs[i].String())
+		}
+	}
+
+	mem, data := sharedData(t, path)
+	defer syscall.Munmap(mem)
+	want := uint64(procs * ((n+2)/3 + (n+1)/3))
+	if data[0] != want || data[1] != want {
+		t.Fatalf("counters = %d, %d; want %d", data[0], data[1], want)
+	}
+
+	p, err := plock.OpenShared(path, sharedLockOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if s := p.State(); s.Mode != plock.Unlocked {
+		t.Fatalf("lock left in %v", s)
+	}
+}
+
+// tempPath returns the path of a file in a new temporary directory, and a
+// function removing the directory
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "plock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "lock"), func() { os.RemoveAll(dir) }
+}
+
+func TestOpenShared(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	p, err := plock.OpenShared(path, 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if fi, err := os.Stat(path); err != nil || fi.Size() != int64(8192+plock.SharedWordSize) {
+		t.Fatalf("file not extended: %v, %v", fi, err)
+	}
+
+	q, err := plock.OpenShared(path, 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	p.SLock()
+	if q.TrySLock() || q.TryWLock() {
+		t.Fatal("second mapping ignored the seek lock")
+	}
+	if !q.TryRLock() {
+		t.Fatal("second mapping could not share the read lock")
+	}
+	q.RUnlock()
+	p.SUnlock()
+
+	if _, err := plock.OpenShared(path, 3); err == nil {
+		t.Fatal("misaligned offset accepted")
+	}
+	if _, err := plock.OpenShared(path, 0, plock.WithParking()); err == nil {
+		t.Fatal("parking accepted on a shared lock")
+	}
+}
+
+func TestNewPMutexAt(t *testing.T) {
+	mem := make([]uint64, 2)
+	b := (*[16]byte)(unsafe.Pointer(&mem[0]))[:]
+
+	if _, err := plock.NewPMutexAt(b, 16-plock.SharedWordSize+1); err == nil {
+		t.Fatal("out of range offset accepted")
+	}
+
+	p, err := plock.NewPMutexAt(b, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := plock.NewPMutexAt(b, 8)
+	p.WLock()
+	if q.TryRLock() {
+		t.Fatal("lock word is not shared")
+	}
+	p.WUnlock()
+	if mem[1] != 0 {
+		t.Fatalf("lock word not released: %x", mem[1])
+	}
+}
+
+func TestSharedCloseUnregisters(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	p, err := plock.OpenShared(path, 0, plock.Named("shared-registered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := plock.OpenRobust(path, 8, plock.Named("shared-registered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(registered("shared-registered")); n != 2 {
+		t.Fatalf("%d shared locks registered, expected 2", n)
+	}
+
+	p.Close()
+	r.Close()
+	if n := len(registered("shared-registered")); n != 0 {
+		t.Fatalf("%d shared locks still registered after Close", n)
+	}
+}
@@ -0,0 +1,82 @@
+package plock_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func expectState(t *testing.T, m *plock.PMutex, mode plock.Mode, readers int, seeker bool, writers int) {
+	s := m.State()
+	if s.Mode != mode || s.Readers != readers || s.Seeker != seeker || s.Writers != writers {
+		t.Errorf("expected %v readers=%d seeker=%t writers=%d, got %+v",
+			mode, readers, seeker, writers, s)
+	}
+	if (s.Word == 0) != (mode == plock.Unlocked) {
+		t.Errorf("raw word %#x doesn't match mode %v", s.Word, mode)
+	}
+}
+
+func TestPMutexState(t *testing.T) {
+	m := &plock.PMutex{}
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+
+	m.RLock()
+	m.RLock()
+	expectState(t, m, plock.Read, 2, false, 0)
+
+	m.RToS()
+	expectState(t, m, plock.Seek, 2, true, 0)
+
+	m.RUnlock()
+	m.SToW()
+	expectState(t, m, plock.Write, 1, true, 1)
+
+	m.WToR()
+	m.RToA()
+	expectState(t, m, plock.Atomic, 0, false, 1)
+	m.ALock()
+	expectState(t, m, plock.Atomic, 0, false, 2)
+	m.AUnlock()
+	m.AUnlock()
+
+	m.RLock()
+	go m.WLock()
+	time.Sleep(50 * time.Millisecond)
+	expectState(t, m, plock.WriteDraining, 2, true, 1)
+	m.RUnlock()
+	time.Sleep(50 * time.Millisecond)
+	expectState(t, m, plock.Write, 1, true, 1)
+	m.WUnlock()
+
+	expectState(t, m, plock.Unlocked, 0, false, 0)
+}
+
+func TestPMutexStateJSON(t *testing.T) {
+	m := &plock.PMutex{}
+	m.SLock()
+	defer m.SUnlock()
+
+	b, err := json.Marshal(m.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s plock.State
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != m.State() {
+		t.Errorf("round trip through %s gave %+v", b, s)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["mode"] != "Seek" {
+		t.Errorf("expected mode to be encoded by name, got %s", b)
+	}
+}
@@ -0,0 +1,203 @@
+package plock_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// publishedLock publishes a new Stats as name, returning a PMutex using it
+func publishedLock(t *testing.T, name string) (*plock.PMutex, *plock.Stats) {
+	s := plock.NewStats()
+	if err := s.Publish(name); err != nil {
+		t.Fatal(err)
+	}
+
+	return plock.NewPMutex(plock.WithStats(s)), s
+}
+
+func TestStatsCounts(t *testing.T) {
+	s := plock.NewStats()
+	m := plock.NewPMutex(plock.WithStats(s))
+
+	m.RLock()
+	m.RLock()
+	m.RUnlock()
+	m.RToS()
+	m.SToW()
+	m.WToR()
+	m.RUnlock()
+	if !m.TryALock() {
+		t.Fatal("TryALock failed on an unlocked lock")
+	}
+	m.AUnlock()
+	m.WLock()
+
+	snap := s.Snapshot()
+	if snap.Read.Acquisitions != 2 || snap.Read.Held != 0 {
+		t.Fatalf("Read: %d acquisitions, %d held, expected 2 and 0", snap.Read.Acquisitions, snap.Read.Held)
+	}
+	if snap.Seek.Acquisitions != 0 || snap.Seek.Held != 0 {
+		t.Fatalf("Seek: %d acquisitions, %d held, expected 0 and 0", snap.Seek.Acquisitions, snap.Seek.Held)
+	}
+	if snap.Write.Acquisitions != 1 || snap.Write.Held != 1 {
+		t.Fatalf("Write: %d acquisitions, %d held, expected 1 and 1", snap.Write.Acquisitions, snap.Write.Held)
+	}
+	if snap.Atomic.Acquisitions != 1 || snap.Atomic.Held != 0 {
+		t.Fatalf("Atomic: %d acquisitions, %d held, expected 1 and 0", snap.Atomic.Acquisitions, snap.Atomic.Held)
+	}
+	expected := plock.Conversions{RToS: 1, SToW: 1, WToR: 1}
+	if snap.Conversions != expected {
+		t.Fatalf("conversions %+v, expected %+v", snap.Conversions, expected)
+	}
+	if n := snap.Read.Wait.Count(); n != 2 {
+		t.Fatalf("%d Read waits recorded, expected 2", n)
+	}
+	if n := snap.Read.Attempts.Counts[0]; n != 2 {
+		t.Fatalf("%d Read acquisitions took 1 attempt, expected 2", n)
+	}
+	// upgrades record their waits, downgrades don't
+	if n := snap.Seek.Wait.Count() + snap.Write.Wait.Count(); n != 3 {
+		t.Fatalf("%d Seek and Write waits recorded, expected 3", n)
+	}
+	if n := snap.Seek.Hold.Count(); n != 1 {
+		t.Fatalf("%d Seek holds recorded, expected 1", n)
+	}
+	m.WUnlock()
+}
+
+func TestStatsWaitAndHold(t *testing.T) {
+	s := plock.NewStats()
+	m := plock.NewPMutex(plock.WithStats(s))
+
+	m.WLock()
+	done := make(chan struct{})
+	go func() {
+		m.RLock()
+		m.RUnlock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	m.WUnlock()
+	<-done
+
+	snap := s.Snapshot()
+	if snap.Read.Contended != 1 {
+		t.Fatalf("%d contended Read acquisitions, expected 1", snap.Read.Contended)
+	}
+	if d := time.Duration(snap.Read.Wait.Sum); d < 10*time.Millisecond {
+		t.Fatalf("Read waited %v, expected at least 10ms", d)
+	}
+	if n := snap.Read.Attempts.Counts[0]; n != 0 {
+		t.Fatalf("%d contended Read acquisitions took 1 attempt, expected none", n)
+	}
+	if d := time.Duration(snap.Write.Hold.Sum); d < 10*time.Millisecond || snap.Write.TotalHold != d {
+		t.Fatalf("Write held %v in total %v, expected at least 10ms in both", d, snap.Write.TotalHold)
+	}
+	if snap.Write.Hold.Counts[len(snap.Write.Hold.Counts)-1] != 0 {
+		t.Fatal("Write hold counted above every bound")
+	}
+
+	m.SLock()
+	time.Sleep(5 * time.Millisecond)
+	if d := s.Snapshot().Seek.TotalHold; d < 5*time.Millisecond {
+		t.Fatalf("Seek Lock held for %v, expected at least 5ms", d)
+	}
+	m.SUnlock()
+}
+
+func TestStatsResetAndSub(t *testing.T) {
+	s := plock.NewStats()
+	m := plock.NewPMutex(plock.WithStats(s))
+
+	m.RLock()
+	m.RUnlock()
+	prev := s.Snapshot()
+	m.RLock()
+	m.RLock()
+	diff := s.Snapshot().Sub(prev)
+	if diff.Read.Acquisitions != 2 || diff.Read.Wait.Count() != 2 || diff.Read.Held != 2 {
+		t.Fatalf("interval: %+v, expected 2 Read acquisitions held", diff.Read)
+	}
+
+	s.Reset()
+	snap := s.Snapshot()
+	if snap.Read.Acquisitions != 0 || snap.Read.Wait.Count() != 0 || snap.Read.Held != 2 {
+		t.Fatalf("after Reset: %+v, expected no acquisitions and 2 held", snap.Read)
+	}
+	m.RUnlock()
+	m.RUnlock()
+	if held := s.Snapshot().Read.Held; held != 0 {
+		t.Fatalf("%d Read Locks held after unlocking, expected 0", held)
+	}
+}
+
+func TestStatsResetWhileInUse(t *testing.T) {
+	s := plock.NewStats()
+	m := plock.NewPMutex(plock.WithStats(s))
+
+	const n = 1000
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				m.RLock()
+				m.RUnlock()
+				m.SLock()
+				m.SToW()
+				m.WUnlock()
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		s.Reset()
+	}
+	wg.Wait()
+
+	snap := s.Snapshot()
+	for _, mode := range []plock.Mode{plock.Read, plock.Seek, plock.Write, plock.Atomic} {
+		if ms := snap.Mode(mode); ms.Held != 0 || ms.TotalHold < 0 {
+			t.Errorf("%v after concurrent Resets: %+v, expected none held", mode, ms)
+		}
+	}
+	if snap.Read.Acquisitions > 4*n {
+		t.Errorf("%d Read acquisitions after concurrent Resets, expected at most %d", snap.Read.Acquisitions, 4*n)
+	}
+}
+
+func TestStatsShared(t *testing.T) {
+	s := plock.NewStats()
+	locks := []*plock.PMutex{plock.NewPMutex(plock.WithStats(s)), plock.NewPMutex(plock.WithStats(s))}
+
+	const n = 100
+	var wg sync.WaitGroup
+	for _, m := range locks {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(m *plock.PMutex) {
+				defer wg.Done()
+				for j := 0; j < n; j++ {
+					m.SLock()
+					m.SToW()
+					m.WUnlock()
+				}
+			}(m)
+		}
+	}
+	wg.Wait()
+
+	snap := s.Snapshot()
+	if snap.Seek.Acquisitions != 8*n || snap.Conversions.SToW != 8*n {
+		t.Fatalf("%d Seek acquisitions and %d SToW, expected %d", snap.Seek.Acquisitions, snap.Conversions.SToW, 8*n)
+	}
+	if snap.Seek.Held != 0 || snap.Write.Held != 0 {
+		t.Fatalf("%d Seek and %d Write Locks held, expected none", snap.Seek.Held, snap.Write.Held)
+	}
+	if c := snap.Seek.Attempts.Count(); c != 8*n {
+		t.Fatalf("%d Seek attempts recorded, expected %d", c, 8*n)
+	}
+}
@@ -0,0 +1,80 @@
+package plock_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestStripedMapsKeysToStripes(t *testing.T) {
+	s := plock.NewStriped(0, nil)
+	if s.Len() != plock.DefaultStripes {
+		t.Fatalf("expected %d stripes, got %d", plock.DefaultStripes, s.Len())
+	}
+	if s.Get("a") != s.Get("a") {
+		t.Fatal("the same key mapped to different stripes")
+	}
+
+	s = plock.NewStriped(8, func(key string) uint64 { return uint64(len(key)) })
+	if s.Index("abc") != 3 || s.Index("123456789") != 1 {
+		t.Fatal("custom hash function was not used")
+	}
+
+	s.WLockKey("abc")
+	if s.Get("xyz").TryRLock() {
+		t.Fatal("keys sharing a stripe don't share a lock")
+	}
+	if !s.Get("ab").TryRLock() {
+		t.Fatal("keys on different stripes share a lock")
+	}
+	s.RUnlockKey("ab")
+	s.WToSKey("abc")
+	s.SToRKey("abc")
+	s.RToWKey("abc")
+	s.WUnlockKey("abc")
+}
+
+func TestStripedLockKeysDoesNotDeadlock(t *testing.T) {
+	s := plock.NewStriped(16, nil)
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	counts := make([]int, len(keys))
+	wg := &sync.WaitGroup{}
+	n := 1000
+	if testing.Short() {
+		n = 50
+	}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < n; i++ {
+				a, b, c := r.Intn(len(keys)), r.Intn(len(keys)), r.Intn(len(keys))
+				s.LockKeys(plock.Write, keys[a], keys[b], keys[c], keys[a])
+				counts[a]++
+				counts[b]++
+				counts[c]++
+				s.UnlockKeys(plock.Write, keys[a], keys[b], keys[c], keys[a])
+
+				s.LockKeys(plock.Read, keys[c], keys[b])
+				s.UnlockKeys(plock.Read, keys[c], keys[b])
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if total != 8*n*3 {
+		t.Fatalf("expected %d increments, got %d", 8*n*3, total)
+	}
+}
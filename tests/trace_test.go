@@ -0,0 +1,72 @@
+//go:build go1.11
+// +build go1.11
+
+package plock_test
+
+import (
+	"bytes"
+	"runtime/trace"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// traced returns the execution trace recorded while running f
+func traced(t *testing.T, f func()) []byte {
+	buf := &bytes.Buffer{}
+	if err := trace.Start(buf); err != nil {
+		t.Skipf("can't trace: %v", err)
+	}
+	f()
+	trace.Stop()
+
+	return buf.Bytes()
+}
+
+// contend holds a Write Lock on m while another goroutine waits to Read Lock
+// it
+func contend(m *plock.PMutex) {
+	m.WLock()
+	done := make(chan struct{})
+	go func() {
+		m.RLock()
+		m.RUnlock()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.WUnlock()
+	<-done
+}
+
+func TestTrace(t *testing.T) {
+	m := plock.NewPMutex(plock.WithName("traced"), plock.WithTrace())
+	data := traced(t, func() { contend(m) })
+
+	if !bytes.Contains(data, []byte("plock.wait traced Read")) {
+		t.Fatal("no wait region in the trace")
+	}
+	if bytes.Contains(data, []byte("plock.hold")) {
+		t.Fatal("hold task traced without WithTraceHolds")
+	}
+}
+
+func TestTraceHolds(t *testing.T) {
+	m := plock.NewPMutex(plock.WithName("held"), plock.WithTraceHolds())
+
+	// held before the trace started, so not traced
+	m.SLock()
+	data := traced(t, func() {
+		m.SUnlock()
+		contend(m)
+		m.RLock()
+		m.RToS()
+		m.SUnlock()
+	})
+
+	for _, s := range []string{"plock.wait held Read", "plock.hold held Write", "plock.hold held Read", "plock.hold held Seek"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("%q missing from the trace", s)
+		}
+	}
+}
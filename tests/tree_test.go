@@ -0,0 +1,199 @@
+package plock_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+var treeModes = []plock.TreeMode{
+	plock.TreeIS, plock.TreeIX, plock.TreeS, plock.TreeSeek,
+	plock.TreeSIX, plock.TreeAtomic, plock.TreeX,
+}
+
+func TestLockNodeCompatibility(t *testing.T) {
+	// rows: requested, columns: held, in the order of treeModes
+	compatible := [][]bool{
+		{true, true, true, true, true, true, false},
+		{true, true, false, false, false, true, false},
+		{true, false, true, true, false, false, false},
+		{true, false, true, false, false, false, false},
+		{true, false, false, false, false, false, false},
+		{true, true, false, false, false, true, false},
+		{false, false, false, false, false, false, false},
+	}
+
+	for i, req := range treeModes {
+		for j, held := range treeModes {
+			var n plock.LockNode
+			n.Lock(held)
+			got := n.TryLock(req)
+			if got != compatible[i][j] {
+				t.Errorf("requesting %v while %v is held: expected %t, got %t",
+					req, held, compatible[i][j], got)
+			}
+			if got {
+				n.Unlock(req)
+			}
+			n.Unlock(held)
+			if !n.TryLock(plock.TreeX) {
+				t.Fatalf("%v/%v left the node locked", req, held)
+			}
+		}
+	}
+}
+
+func TestLockNodeIntentions(t *testing.T) {
+	var db plock.LockNode
+	table := db.Child("accounts")
+	row1 := table.Child("page 1").Child("row 1")
+	row2 := table.Child("page 1").Child("row 2")
+	if row1.Parent().Parent() != table || row1.Name() != "row 1" {
+		t.Fatal("Child did not build the expected tree")
+	}
+
+	row1.Lock(plock.TreeX)
+	if table.TryLock(plock.TreeS) {
+		t.Fatal("table read locked while one of its rows is write locked")
+	}
+	if !row2.TryLock(plock.TreeX) {
+		t.Fatal("sibling row could not be write locked")
+	}
+	row2.Unlock(plock.TreeX)
+	if !table.TryLock(plock.TreeIS) {
+		t.Fatal("IS on table should be compatible with IX")
+	}
+	table.Unlock(plock.TreeIS)
+	row1.Unlock(plock.TreeX)
+
+	table.Lock(plock.TreeS)
+	if row1.TryLock(plock.TreeX) {
+		t.Fatal("row write locked while its table is read locked")
+	}
+	if !row1.TryLock(plock.TreeS) {
+		t.Fatal("row could not be read locked while its table is read locked")
+	}
+	row1.Unlock(plock.TreeS)
+
+	// SIX: read the whole table, and write some rows
+	table.Convert(plock.TreeS, plock.TreeSIX)
+	if row1.TryLock(plock.TreeX) {
+		t.Fatal("row write locked by another goroutine under SIX")
+	}
+	row1.LockUnder(table, plock.TreeX)
+	if row1.TryLock(plock.TreeS) {
+		t.Fatal("row read locked while written under SIX")
+	}
+	if !row2.TryLock(plock.TreeS) {
+		t.Fatal("SIX should let others read rows that aren't written")
+	}
+	row2.Unlock(plock.TreeS)
+	row1.UnlockUnder(table, plock.TreeX)
+	table.Unlock(plock.TreeSIX)
+
+	if !db.TryLock(plock.TreeX) {
+		t.Fatal("locks were left behind on the root")
+	}
+	db.Unlock(plock.TreeX)
+}
+
+func TestLockNodeConvert(t *testing.T) {
+	var db plock.LockNode
+	table := db.Child("t")
+	row := table.Child("r")
+
+	row.Lock(plock.TreeS)
+	row.Convert(plock.TreeS, plock.TreeSeek)
+	if !table.TryLock(plock.TreeS) {
+		t.Fatal("ancestor should only hold IS, which allows S")
+	}
+	table.Unlock(plock.TreeS)
+	row.Convert(plock.TreeSeek, plock.TreeX)
+	if table.TryLock(plock.TreeS) {
+		t.Fatal("upgrade to X did not upgrade the ancestor intentions")
+	}
+	row.Convert(plock.TreeX, plock.TreeS)
+	if !table.TryLock(plock.TreeS) {
+		t.Fatal("downgrade to S did not downgrade the ancestor intentions")
+	}
+	table.Unlock(plock.TreeS)
+	row.Unlock(plock.TreeS)
+
+	if !db.TryLock(plock.TreeX) {
+		t.Fatal("locks were left behind on the root")
+	}
+}
+
+func TestLockNodeHammer(t *testing.T) {
+	var db plock.LockNode
+	n := 500
+	if testing.Short() {
+		n = 20
+	}
+
+	var tableSum int
+	rows := make([]int, 4)
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			table := db.Child("t")
+			for i := 0; i < n; i++ {
+				r := (g + i) % len(rows)
+				row := table.Child(fmt.Sprint(r))
+				switch i % 3 {
+				case 0:
+					row.Lock(plock.TreeX)
+					rows[r]++
+					row.Unlock(plock.TreeX)
+				case 1:
+					row.Lock(plock.TreeSeek)
+					row.Convert(plock.TreeSeek, plock.TreeX)
+					rows[r]++
+					row.Unlock(plock.TreeX)
+				case 2:
+					table.Lock(plock.TreeX)
+					tableSum++
+					table.Unlock(plock.TreeX)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	total := tableSum
+	for _, r := range rows {
+		total += r
+	}
+	if total != 8*n {
+		t.Fatalf("expected %d updates, got %d", 8*n, total)
+	}
+}
+
+func TestLockNodeTooManyHolders(t *testing.T) {
+	var n plock.LockNode
+	for i := 0; i < plock.MaxTreeHolders; i++ {
+		n.Lock(plock.TreeS)
+	}
+
+	msg := panicMessage(func() { n.TryLock(plock.TreeS) })
+	if !strings.Contains(msg, "holders of a LockNode in mode S") {
+		t.Fatalf("TryLock panicked with %q", msg)
+	}
+	if !n.TryLock(plock.TreeIS) {
+		t.Fatal("the overflow spilled into another mode")
+	}
+	n.Unlock(plock.TreeIS)
+
+	for i := 0; i < plock.MaxTreeHolders; i++ {
+		n.Unlock(plock.TreeS)
+	}
+	if !n.TryLock(plock.TreeX) {
+		t.Fatal("TryLock(TreeX) failed once every holder left")
+	}
+	n.Unlock(plock.TreeX)
+}
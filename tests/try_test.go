@@ -0,0 +1,143 @@
+package plock_test
+
+import (
+	"testing"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestPMutexTryLockModes(t *testing.T) {
+	m := &plock.PMutex{}
+	unlocked := m.String()
+
+	if !m.TryRLock() {
+		t.Fatal("TryRLock failed on an unlocked mutex")
+	}
+	if !m.TryRLock() {
+		t.Fatal("TryRLock failed with only readers present")
+	}
+	if !m.TrySLock() {
+		t.Fatal("TrySLock failed with only readers present")
+	}
+
+	before := m.String()
+	if m.TrySLock() {
+		t.Fatal("TrySLock succeeded while a Seek Lock was held")
+	}
+	if m.TryWLock() {
+		t.Fatal("TryWLock succeeded while a Seek Lock was held")
+	}
+	if m.TryALock() {
+		t.Fatal("TryALock succeeded while a Seek Lock was held")
+	}
+	if after := m.String(); before != after {
+		t.Errorf("failed Try* changed lock state from %s to %s", before, after)
+	}
+
+	m.SUnlock()
+	m.RUnlock()
+	m.RUnlock()
+	if m.String() != unlocked {
+		t.Fatal(m.String())
+	}
+
+	if !m.TryWLock() {
+		t.Fatal("TryWLock failed on an unlocked mutex")
+	}
+	before = m.String()
+	if m.TryRLock() || m.TrySLock() || m.TryWLock() || m.TryALock() {
+		t.Fatal("Try* succeeded while a Write Lock was held")
+	}
+	if after := m.String(); before != after {
+		t.Errorf("failed Try* changed lock state from %s to %s", before, after)
+	}
+	m.WUnlock()
+
+	if !m.TryALock() || !m.TryALock() {
+		t.Fatal("TryALock should allow multiple atomic writers")
+	}
+	if m.TryRLock() || m.TrySLock() || m.TryWLock() {
+		t.Fatal("Try* succeeded while an Atomic Write Lock was held")
+	}
+	m.AUnlock()
+	m.AUnlock()
+	if m.String() != unlocked {
+		t.Fatal(m.String())
+	}
+}
+
+func TestPMutexTryWLockDoesNotWaitForReaders(t *testing.T) {
+	m := &plock.PMutex{}
+	m.RLock()
+	before := m.String()
+
+	if m.TryWLock() {
+		t.Fatal("TryWLock succeeded while a reader was present")
+	}
+	if after := m.String(); before != after {
+		t.Errorf("TryWLock left bits behind: %s -> %s", before, after)
+	}
+	if m.TryALock() {
+		t.Fatal("TryALock succeeded while a reader was present")
+	}
+	if after := m.String(); before != after {
+		t.Errorf("TryALock left bits behind: %s -> %s", before, after)
+	}
+
+	// a new reader must still be admitted, i.e. nothing is half-taken
+	if !m.TryRLock() {
+		t.Fatal("TryRLock failed after a failed TryWLock")
+	}
+	m.RUnlock()
+	m.RUnlock()
+}
+
+func TestPMutexTryUpgrades(t *testing.T) {
+	m := &plock.PMutex{}
+	unlocked := m.String()
+
+	m.RLock()
+	m.RLock()
+	before := m.String()
+	if m.TryRToW() {
+		t.Fatal("TryRToW succeeded with another reader present")
+	}
+	if m.TryRToA() {
+		t.Fatal("TryRToA succeeded with another reader present")
+	}
+	if after := m.String(); before != after {
+		t.Errorf("failed upgrade changed lock state from %s to %s", before, after)
+	}
+
+	if !m.TryRToS() {
+		t.Fatal("TryRToS failed with only readers present")
+	}
+	if m.TryRToS() {
+		t.Fatal("TryRToS succeeded while a Seek Lock was held")
+	}
+	before = m.String()
+	if m.TrySToW() {
+		t.Fatal("TrySToW succeeded with another reader present")
+	}
+	if after := m.String(); before != after {
+		t.Errorf("failed TrySToW changed lock state from %s to %s", before, after)
+	}
+
+	m.RUnlock()
+	if !m.TrySToW() {
+		t.Fatal("TrySToW failed with no other readers present")
+	}
+	m.WToR()
+	if !m.TryRToW() {
+		t.Fatal("TryRToW failed with no other readers present")
+	}
+	m.WToR()
+	if !m.TryRToA() {
+		t.Fatal("TryRToA failed with no other readers present")
+	}
+	m.AUnlock()
+
+	if m.String() != unlocked {
+		t.Fatal(m.String())
+	}
+}
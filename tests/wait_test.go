@@ -0,0 +1,132 @@
+package plock_test
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+func TestPMutexWaitStrategies(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	n := 100
+	if testing.Short() {
+		n = 5
+	}
+
+	for _, s := range waitStrategies {
+		t.Run(s.name, func(t *testing.T) {
+			hammerPMutex(plock.NewPMutex(plock.WithWaitStrategy(s.new())), 4, 3, n)
+			hammerPMutex(plock.NewPMutex(plock.WithWaitStrategy(s.new())), 10, 10, n)
+		})
+	}
+}
+
+func TestPMutexParkWakesOnUnlock(t *testing.T) {
+	// with a huge max, only Wake can get the waiter going again in time
+	m := plock.NewPMutex(plock.WithWaitStrategy(plock.Park(time.Hour)))
+	m.WLock()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		m.RLock()
+		m.RUnlock()
+		wg.Done()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	m.WUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parked reader was not woken by WUnlock")
+	}
+}
+
+// pausedContext blocks the first call to Done, which a blocking acquisition
+// makes between a failed attempt and waiting, until resume is closed
+type pausedContext struct {
+	context.Context
+	once           sync.Once
+	paused, resume chan struct{}
+}
+
+func (c *pausedContext) Done() <-chan struct{} {
+	c.once.Do(func() {
+		close(c.paused)
+		<-c.resume
+	})
+
+	return c.Context.Done()
+}
+
+func TestPMutexParkWakeRacingAttempt(t *testing.T) {
+	const max = time.Hour
+	m := plock.NewPMutex(plock.WithWaitStrategy(plock.Park(max)))
+	m.WLock()
+
+	ctx := &pausedContext{
+		Context: context.Background(),
+		paused:  make(chan struct{}),
+		resume:  make(chan struct{}),
+	}
+	done := make(chan error)
+	go func() {
+		done <- m.RLockContext(ctx)
+	}()
+
+	// release between the reader's failed attempt and it parking
+	<-ctx.paused
+	m.WUnlock()
+	close(ctx.resume)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.RUnlock()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reader missed the WUnlock racing its attempt, and parked for up to %v", max)
+	}
+}
+
+func TestPMutexWaitStrategiesHonourContext(t *testing.T) {
+	strategies := map[string]plock.WaitStrategy{
+		"Backoff": plock.Backoff(time.Microsecond, time.Hour),
+		"Sleep":   plock.Sleep(time.Hour),
+		"Park":    plock.Park(time.Hour),
+	}
+	for name, s := range strategies {
+		m := plock.NewPMutex(plock.WithWaitStrategy(s))
+		m.RLock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		err := m.WLockContext(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%s: expected %v, got %v", name, context.DeadlineExceeded, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("%s: WLockContext returned after %v, past its 20ms deadline", name, d)
+		}
+		m.RUnlock()
+	}
+}
+
+func TestNewPMutexWithoutOptionsIsZeroValue(t *testing.T) {
+	if *plock.NewPMutex() != (plock.PMutex{}) {
+		t.Fatal("NewPMutex() differs from the zero value")
+	}
+}
@@ -0,0 +1,263 @@
+package plock_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardsamuels/go-plock"
+)
+
+// waitForWaiting waits until n goroutines are waiting for p
+func waitForWaiting(t *testing.T, p *plock.PMutex, n int) []plock.Waiter {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if w := p.Waiting(); len(w) == n {
+			return w
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines waiting, expected %d", len(p.Waiting()), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWaitForGraph(t *testing.T) {
+	a := plock.NewPMutex(plock.WithName("a"), plock.WithOwnership())
+	b := plock.NewPMutex(plock.WithName("b"), plock.WithOwnership())
+	c := plock.NewPMutex(plock.WithName("c"), plock.WithOwnership())
+
+	// each goroutine write locks one lock, then waits for the other
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	locked := make(chan struct{})
+	start := make(chan struct{})
+	done := make(chan struct{}, 2)
+	lockBoth := func(first, second *plock.PMutex) {
+		first.WLock()
+		locked <- struct{}{}
+		<-start
+		// once one gives up, the other may succeed
+		if second.WLockContext(ctx) == nil {
+			second.WUnlock()
+		}
+		first.WUnlock()
+		done <- struct{}{}
+	}
+	go lockBoth(a, b)
+	go lockBoth(b, a)
+	<-locked
+	<-locked
+	close(start)
+	waiters := waitForWaiting(t, a, 1)
+	waitForWaiting(t, b, 1)
+	if waiters[0].Mode != plock.Write || !strings.Contains(waiters[0].Stack, "TestWaitForGraph") {
+		t.Fatalf("unexpected waiter %v", waiters[0])
+	}
+
+	// waiting for c, but not part of the deadlock
+	c.RLock()
+	errs := make(chan error)
+	go func() {
+		errs <- c.WLockContext(ctx)
+	}()
+	waitForWaiting(t, c, 1)
+
+	buf := &bytes.Buffer{}
+	if err := plock.WriteWaitForGraph(buf, a, b, c); err != nil {
+		t.Fatal(err)
+	}
+	graph := buf.String()
+	labels := []string{
+		`L0 [shape=box, label="a\n` + a.State().String() + `"];`,
+		`L2 [shape=box, label="c\n` + c.State().String() + `"];`,
+	}
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("WLockContext returned %v, expected %v", err, context.Canceled)
+	}
+	c.RUnlock()
+	<-done
+	<-done
+
+	if !strings.HasPrefix(graph, "digraph plock {\n") || !strings.HasSuffix(graph, "}\n") {
+		t.Fatalf("not a DOT graph:\n%s", graph)
+	}
+	for _, s := range labels {
+		if !strings.Contains(graph, s) {
+			t.Errorf("missing %q in:\n%s", s, graph)
+		}
+	}
+
+	edge := regexp.MustCompile(`(?m)^\t(\w+) -> (\w+) \[(.*)\];$`)
+	red := make(map[string]bool)
+	for _, m := range edge.FindAllStringSubmatch(graph, -1) {
+		red[m[1]+" -> "+m[2]] = strings.Contains(m[3], ", color=red")
+	}
+	// the writer of c drains its reader, so is drawn holding it too
+	if len(red) != 7 {
+		t.Fatalf("%d edges, expected 7:\n%s", len(red), graph)
+	}
+	for e, isRed := range red {
+		if onC := strings.Contains(e, "L2"); isRed == onC {
+			t.Errorf("edge %s colored wrongly:\n%s", e, graph)
+		}
+	}
+}
+
+func TestWaitForGraphUpgrades(t *testing.T) {
+	m := plock.NewPMutex(plock.Named("wait-for-upgrades"), plock.WithOwnership())
+	defer m.Unregister()
+
+	// two readers upgrading to Write Locks deadlock
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	locked := make(chan struct{})
+	start := make(chan struct{})
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			m.RLock()
+			locked <- struct{}{}
+			<-start
+			// once one gives up, the other may succeed
+			if err := m.RToWContext(ctx); err != nil {
+				m.RUnlock()
+			} else {
+				m.WUnlock()
+			}
+			done <- struct{}{}
+		}()
+	}
+	<-locked
+	<-locked
+	close(start)
+	waitForWaiting(t, m, 2)
+
+	body, _ := get(t, withQuery{plock.RegistryHandler(), "format=dot"})
+	cancel()
+	<-done
+	<-done
+
+	// both goroutines, both waits, both Read Locks and the Write Lock
+	// claimed by the one draining readers
+	if n := strings.Count(body, ", color=red"); n != 7 {
+		t.Fatalf("%d red nodes and edges, expected 7:\n%s", n, body)
+	}
+	if !strings.Contains(body, `[label="draining Write", color=red`) {
+		t.Fatalf("draining upgrade not drawn as a holder:\n%s", body)
+	}
+}
+
+func TestWaitForGraphSelfDeadlock(t *testing.T) {
+	m := plock.NewPMutex(plock.WithName("self"), plock.WithOwnership())
+
+	// write locking a lock the goroutine reads waits for itself
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error)
+	go func() {
+		m.RLock()
+		err := m.WLockContext(ctx)
+		m.RUnlock()
+		errs <- err
+	}()
+	waitForWaiting(t, m, 1)
+
+	buf := &bytes.Buffer{}
+	if err := plock.WriteWaitForGraph(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("WLockContext returned %v, expected %v", err, context.Canceled)
+	}
+
+	// the goroutine, its wait and its Read Lock, but not the Write Lock it
+	// drains, which it doesn't wait for
+	graph := buf.String()
+	if n := strings.Count(graph, ", color=red"); n != 3 {
+		t.Fatalf("%d red nodes and edges, expected 3:\n%s", n, graph)
+	}
+	if !strings.Contains(graph, `[label="held Read", color=red`) {
+		t.Fatalf("Read Lock not in the cycle:\n%s", graph)
+	}
+}
+
+func TestWaitForGraphDrainingWriter(t *testing.T) {
+	m := plock.NewPMutex(plock.WithName("m"), plock.WithOwnership())
+	n := plock.NewPMutex(plock.WithName("n"), plock.WithOwnership())
+
+	// the reader of m waits for n, held by a goroutine waiting to read m,
+	// which waits for the writer draining the reader of m
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	locked := make(chan struct{})
+	start := make(chan struct{})
+	done := make(chan struct{}, 3)
+	go func() {
+		m.RLock()
+		locked <- struct{}{}
+		<-start
+		if n.RLockContext(ctx) == nil {
+			n.RUnlock()
+		}
+		m.RUnlock()
+		done <- struct{}{}
+	}()
+	go func() {
+		n.WLock()
+		locked <- struct{}{}
+		<-start
+		waitForWaiting(t, m, 1)
+		if m.RLockContext(ctx) == nil {
+			m.RUnlock()
+		}
+		n.WUnlock()
+		done <- struct{}{}
+	}()
+	<-locked
+	<-locked
+	go func() {
+		if m.WLockContext(ctx) == nil {
+			m.WUnlock()
+		}
+		done <- struct{}{}
+	}()
+	waitForWaiting(t, m, 1)
+	close(start)
+	waitForWaiting(t, m, 2)
+	waitForWaiting(t, n, 1)
+
+	buf := &bytes.Buffer{}
+	if err := plock.WriteWaitForGraph(buf, m, n); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-done
+	<-done
+	<-done
+
+	// the three goroutines, their waits, and the locks each holds or drains
+	graph := buf.String()
+	if c := strings.Count(graph, ", color=red"); c != 9 {
+		t.Fatalf("%d red nodes and edges, expected 9:\n%s", c, graph)
+	}
+	if !strings.Contains(graph, `[label="draining Write", color=red`) {
+		t.Fatalf("draining writer not drawn as a holder:\n%s", graph)
+	}
+}
+
+// withQuery serves requests with their query replaced
+type withQuery struct {
+	http.Handler
+	query string
+}
+
+func (h withQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.URL.RawQuery = h.query
+	h.Handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,131 @@
+//go:build go1.11
+// +build go1.11
+
+package plock
+
+import (
+	"context"
+	"runtime/trace"
+	"sync"
+)
+
+// WithTrace makes the PMutex annotate execution traces: while a trace is
+// being recorded, every acquisition or upgrade that has to wait runs in a
+// region named "plock.wait <name> <mode>", so go tool trace attributes the
+// time goroutines spend yielding or sleeping to the lock. The lock is named
+// as by WithName. Outside of traces, this costs a check per contended
+// acquisition. Before Go 1.11, which added regions to runtime/trace, this
+// does nothing
+func WithTrace() Option {
+	return func(o *options) {
+		if o.trace == nil {
+			o.trace = &tracer{}
+		}
+	}
+}
+
+// WithTraceHolds makes the PMutex annotate execution traces as WithTrace
+// does, and also records each lock held as a task named
+// "plock.hold <name> <mode>", from its acquisition to its release. Tasks
+// rather than regions are used as a lock may be released by another
+// goroutine, and locks needn't be released in the reverse order they were
+// taken in. While a trace is being recorded, this costs a task and a
+// goroutine lookup per acquisition. Before Go 1.11, this does nothing
+func WithTraceHolds() Option {
+	return func(o *options) {
+		o.trace = &tracer{holds: true}
+	}
+}
+
+// tracer annotates execution traces for a PMutex
+type tracer struct {
+	holds bool
+
+	mu sync.Mutex
+	// tasks are the holds of the PMutex that are being traced, oldest first
+	tasks []traceHold
+}
+
+// traceHold is a lock held while a trace was recorded
+type traceHold struct {
+	goroutine int64
+	mode      Mode
+	task      *trace.Task
+}
+
+// traceRegion is the execution trace region of a wait, if one was started
+type traceRegion struct {
+	r *trace.Region
+}
+
+func (r traceRegion) end() {
+	if r.r != nil {
+		r.r.End()
+	}
+}
+
+// waitBegan starts the region of a wait for p in mode m, unless no trace is
+// being recorded
+func (t *tracer) waitBegan(p *PMutex, m Mode) traceRegion {
+	if !trace.IsEnabled() {
+		return traceRegion{}
+	}
+
+	return traceRegion{trace.StartRegion(context.Background(), "plock.wait "+p.name()+" "+m.String())}
+}
+
+func (t *tracer) acquired(p *PMutex, m Mode) {
+	if !t.holds || !trace.IsEnabled() {
+		return
+	}
+
+	_, task := trace.NewTask(context.Background(), "plock.hold "+p.name()+" "+m.String())
+	rec := traceHold{goroutine: goid(), mode: m, task: task}
+
+	t.mu.Lock()
+	t.tasks = append(t.tasks, rec)
+	t.mu.Unlock()
+}
+
+// released ends the task of the calling goroutine's hold in mode m or,
+// failing that, of the oldest hold in mode m
+func (t *tracer) released(m Mode) {
+	if !t.holds {
+		return
+	}
+
+	t.mu.Lock()
+	if len(t.tasks) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	g := goid()
+	found := -1
+	for i := range t.tasks {
+		if t.tasks[i].mode != m {
+			continue
+		}
+		if t.tasks[i].goroutine == g {
+			found = i
+			break
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	var task *trace.Task
+	if found >= 0 {
+		task = t.tasks[found].task
+		t.tasks = append(t.tasks[:found], t.tasks[found+1:]...)
+	}
+	t.mu.Unlock()
+
+	if task != nil {
+		task.End()
+	}
+}
+
+func (t *tracer) converted(p *PMutex, from, to Mode) {
+	t.released(from)
+	t.acquired(p, to)
+}
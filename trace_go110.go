@@ -0,0 +1,31 @@
+//go:build !go1.11
+// +build !go1.11
+
+package plock
+
+// WithTrace would make the PMutex annotate execution traces with the regions
+// added to runtime/trace by Go 1.11. Before that, it does nothing
+func WithTrace() Option {
+	return func(o *options) {}
+}
+
+// WithTraceHolds would make the PMutex annotate execution traces with the
+// tasks added to runtime/trace by Go 1.11. Before that, it does nothing
+func WithTraceHolds() Option {
+	return func(o *options) {}
+}
+
+// tracer is never set before Go 1.11
+type tracer struct{}
+
+type traceRegion struct{}
+
+func (traceRegion) end() {}
+
+func (t *tracer) waitBegan(p *PMutex, m Mode) traceRegion { return traceRegion{} }
+
+func (t *tracer) acquired(p *PMutex, m Mode) {}
+
+func (t *tracer) released(m Mode) {}
+
+func (t *tracer) converted(p *PMutex, from, to Mode) {}
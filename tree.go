@@ -0,0 +1,316 @@
+package plock
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// TreeMode is a lock mode of a LockNode. Besides the progressive modes of
+// PMutex, nodes support the intention modes of multi-granularity locking
+// (Gray et al., "Granularity of Locks in a Shared Data Base"): an intention
+// lock on a node announces that its holder locks some of the node's
+// descendants, in the corresponding mode.
+//
+// Compatibility of a requested mode (rows) with a held mode (columns):
+//
+//	        IS  IX  S   Seek SIX A   X
+//	IS      y   y   y   y    y   y   n
+//	IX      y   y   n   n    n   y   n
+//	S       y   n   y   y    n   n   n
+//	Seek    y   n   y   n    n   n   n
+//	SIX     y   n   n   n    n   n   n
+//	A       y   y   n   n    n   y   n
+//	X       n   n   n   n    n   n   n
+type TreeMode uint8
+
+const (
+	// TreeIS (intention shared) announces S or Seek locks on descendants
+	TreeIS TreeMode = iota
+	// TreeIX (intention exclusive) announces locks of any mode on
+	// descendants
+	TreeIX
+	// TreeS (shared) is the Read Lock of the node and all its descendants
+	TreeS
+	// TreeSeek is the Seek Lock of the node and all its descendants: an
+	// exclusive reader, which may upgrade to TreeX once readers leave
+	TreeSeek
+	// TreeSIX is TreeS and TreeIX at once: the node and its descendants are
+	// read, and some descendants are written
+	TreeSIX
+	// TreeAtomic is the Atomic Write Lock of the node: several writers may
+	// hold it at once, and must access the node's data atomically
+	TreeAtomic
+	// TreeX (exclusive) is the Write Lock of the node and all its descendants
+	TreeX
+
+	numTreeModes
+)
+
+var treeModeNames = [numTreeModes]string{
+	TreeIS:     "IS",
+	TreeIX:     "IX",
+	TreeS:      "S",
+	TreeSeek:   "Seek",
+	TreeSIX:    "SIX",
+	TreeAtomic: "A",
+	TreeX:      "X",
+}
+
+func (m TreeMode) String() string {
+	if m >= numTreeModes {
+		return "TreeMode(" + strconv.Itoa(int(m)) + ")"
+	}
+	return treeModeNames[m]
+}
+
+// treeOne is what a single holder of each mode adds to the lock word
+var treeOne = [numTreeModes]uint64{
+	TreeIS:     treeIS1,
+	TreeIX:     treeIX1,
+	TreeS:      treeS1,
+	TreeSeek:   treeSeek1,
+	TreeSIX:    treeSIX1,
+	TreeAtomic: treeA1,
+	TreeX:      treeX1,
+}
+
+// treeAny masks the count of each mode in the lock word
+var treeAny = [numTreeModes]uint64{
+	TreeIS:     treeISAny,
+	TreeIX:     treeIXAny,
+	TreeS:      treeSAny,
+	TreeSeek:   treeSeekAny,
+	TreeSIX:    treeSIXAny,
+	TreeAtomic: treeAAny,
+	TreeX:      treeXAny,
+}
+
+// treeConflicts masks the held modes each mode can't be granted alongside
+var treeConflicts = [numTreeModes]uint64{
+	TreeIS:     treeXAny,
+	TreeIX:     treeSAny | treeSeekAny | treeSIXAny | treeXAny,
+	TreeS:      treeIXAny | treeSIXAny | treeAAny | treeXAny,
+	TreeSeek:   treeIXAny | treeSeekAny | treeSIXAny | treeAAny | treeXAny,
+	TreeSIX:    treeIXAny | treeSAny | treeSeekAny | treeSIXAny | treeAAny | treeXAny,
+	TreeAtomic: treeSAny | treeSeekAny | treeSIXAny | treeXAny,
+	TreeX:      treeISAny | treeIXAny | treeSAny | treeSeekAny | treeSIXAny | treeAAny | treeXAny,
+}
+
+// intention returns the mode ancestors must hold for a node to be locked in m
+func (m TreeMode) intention() TreeMode {
+	switch m {
+	case TreeIS, TreeS, TreeSeek:
+		return TreeIS
+	}
+	return TreeIX
+}
+
+func (m TreeMode) check() {
+	if m >= numTreeModes {
+		panic(fmt.Sprintf("plock: invalid tree mode %v", m))
+	}
+}
+
+// LockNode is a node of a tree of locks, such as a table containing pages
+// containing rows. Locking a node in any mode first acquires the matching
+// intention mode on every ancestor, from the root down, so that a lock on a
+// node also covers its descendants: for instance, a TreeS lock on a table
+// can't be granted while a row of it is locked in TreeX.
+//
+// Each node's state is a single lock word, holding a count per mode in the
+// manner of PMutex. Waiters yield to the scheduler between attempts.
+//
+// The counts of TreeIS, TreeIX, TreeS and TreeAtomic are 14 bits wide, so
+// at most MaxTreeHolders goroutines may hold a node in each of those modes at
+// once; locking one more panics. Intention locks count against the limit of
+// every ancestor.
+//
+// The zero value is an unlocked root node
+type LockNode struct {
+	// word comes first to be 64 bit aligned on 32 bit platforms
+	word uint64
+
+	name   string
+	parent *LockNode
+
+	mu       sync.Mutex
+	children map[string]*LockNode
+}
+
+// Child returns the child of n with the given name, creating it if needed.
+// Children are never removed
+func (n *LockNode) Child(name string) *LockNode {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.children == nil {
+		n.children = make(map[string]*LockNode)
+	}
+	c := n.children[name]
+	if c == nil {
+		c = &LockNode{name: name, parent: n}
+		n.children[name] = c
+	}
+
+	return c
+}
+
+// Name returns the name n was created with by Child. The root has no name
+func (n *LockNode) Name() string {
+	return n.name
+}
+
+// Parent returns the parent of n, or nil for the root
+func (n *LockNode) Parent() *LockNode {
+	return n.parent
+}
+
+// ancestors returns the ancestors of n strictly below held, from the top
+// down. A nil held returns every ancestor
+func (n *LockNode) ancestors(held *LockNode) []*LockNode {
+	var a []*LockNode
+	for p := n.parent; p != nil && p != held; p = p.parent {
+		a = append(a, p)
+	}
+	for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+		a[i], a[j] = a[j], a[i]
+	}
+
+	return a
+}
+
+// tryConvert attempts to trade a held from lock for a to lock on n alone. A
+// nil from means nothing is held. It panics if the count of to is full, as
+// adding to it would carry into the next mode's
+func (n *LockNode) tryConvert(from *TreeMode, to TreeMode) bool {
+	for {
+		v := atomic.LoadUint64(&n.word)
+		rest := v
+		if from != nil {
+			rest -= treeOne[*from]
+		}
+		if rest&treeConflicts[to] != 0 {
+			return false
+		}
+		if rest&treeAny[to] == treeAny[to] {
+			panic(fmt.Sprintf("plock: more than %d holders of a LockNode in mode %v", MaxTreeHolders, to))
+		}
+		if atomic.CompareAndSwapUint64(&n.word, v, rest+treeOne[to]) {
+			return true
+		}
+	}
+}
+
+func (n *LockNode) convert(from *TreeMode, to TreeMode) {
+	for !n.tryConvert(from, to) {
+		runtime.Gosched()
+	}
+}
+
+func (n *LockNode) release(m TreeMode) {
+	_ = subUint64(&n.word, treeOne[m])
+}
+
+// Lock locks n in mode m, after locking every ancestor in the matching
+// intention mode
+func (n *LockNode) Lock(m TreeMode) {
+	n.LockUnder(nil, m)
+}
+
+// LockUnder is like Lock, but only locks the ancestors of n strictly below
+// held. held must be an ancestor of n that the caller already holds in a mode
+// covering m: TreeIX or TreeSIX for the modes that write, or any but TreeAtomic
+// for those that read. This is how a TreeSIX holder write locks descendants,
+// since TreeIX conflicts with its own TreeSIX
+func (n *LockNode) LockUnder(held *LockNode, m TreeMode) {
+	m.check()
+	im := m.intention()
+	for _, a := range n.ancestors(held) {
+		a.convert(nil, im)
+	}
+	n.convert(nil, m)
+}
+
+// TryLock attempts to lock n in mode m, and every ancestor in the matching
+// intention mode, without blocking. It returns true if all of them were
+// locked; otherwise nothing is held
+func (n *LockNode) TryLock(m TreeMode) bool {
+	return n.TryLockUnder(nil, m)
+}
+
+// TryLockUnder is to TryLock what LockUnder is to Lock
+func (n *LockNode) TryLockUnder(held *LockNode, m TreeMode) bool {
+	m.check()
+	im := m.intention()
+	anc := n.ancestors(held)
+	for i, a := range anc {
+		if !a.tryConvert(nil, im) {
+			for j := i - 1; j >= 0; j-- {
+				anc[j].release(im)
+			}
+			return false
+		}
+	}
+	if n.tryConvert(nil, m) {
+		return true
+	}
+	for j := len(anc) - 1; j >= 0; j-- {
+		anc[j].release(im)
+	}
+
+	return false
+}
+
+// Unlock releases a lock on n in mode m, and the intention locks Lock took
+// on its ancestors
+func (n *LockNode) Unlock(m TreeMode) {
+	n.UnlockUnder(nil, m)
+}
+
+// UnlockUnder releases a lock taken by LockUnder with the same held
+func (n *LockNode) UnlockUnder(held *LockNode, m TreeMode) {
+	m.check()
+	n.release(m)
+	im := m.intention()
+	for p := n.parent; p != nil && p != held; p = p.parent {
+		p.release(im)
+	}
+}
+
+// Convert trades a lock on n held in mode from for one in mode to, adjusting
+// the intention locks on its ancestors to match. The paths of PMutex map to
+// TreeS -> TreeSeek (RToS), TreeSeek -> TreeX (SToW), TreeS -> TreeX (RToW),
+// TreeS -> TreeAtomic (RToA), and their reverse downgrades; upgrades to and
+// from the intention modes, such as TreeIS -> TreeIX or TreeS -> TreeSIX,
+// work the same way.
+//
+// As with PMutex, two holders upgrading at once may deadlock waiting for
+// each other; TreeSeek exists to avoid this
+func (n *LockNode) Convert(from, to TreeMode) {
+	n.ConvertUnder(nil, from, to)
+}
+
+// ConvertUnder converts a lock taken by LockUnder with the same held. held
+// must already cover both from and to
+func (n *LockNode) ConvertUnder(held *LockNode, from, to TreeMode) {
+	from.check()
+	to.check()
+	fi, ti := from.intention(), to.intention()
+
+	// strengthen ancestors from the top down before n, and weaken them from
+	// n up after, so the ancestors always cover n
+	if fi != ti && ti == TreeIX {
+		for _, a := range n.ancestors(held) {
+			a.convert(&fi, ti)
+		}
+	}
+	n.convert(&from, to)
+	if fi != ti && ti == TreeIS {
+		for p := n.parent; p != nil && p != held; p = p.parent {
+			p.convert(&fi, ti)
+		}
+	}
+}
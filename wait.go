@@ -0,0 +1,260 @@
+package plock
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WaitStrategy decides what a goroutine does after failing to acquire a
+// PMutex, before it tries again. Implementations must be safe for concurrent
+// use, as every waiter on a PMutex shares its strategy
+type WaitStrategy interface {
+	// Wait is called after a failed attempt to acquire the lock. attempt
+	// counts the consecutive failures of the current acquisition, starting
+	// at 0
+	Wait(attempt int)
+}
+
+// ContextWaitStrategy is a WaitStrategy that can stop waiting early when the
+// context of a *Context acquisition is done. Strategies that sleep or block
+// should implement it, so that waits stay bounded by the caller's deadline
+type ContextWaitStrategy interface {
+	WaitStrategy
+
+	// WaitContext is Wait, returning early once ctx is done
+	WaitContext(ctx context.Context, attempt int)
+}
+
+// WakingStrategy is a WaitStrategy that must be notified whenever the lock it
+// waits on is released or downgraded
+type WakingStrategy interface {
+	WaitStrategy
+
+	// Wake is called after the lock has been released or downgraded
+	Wake()
+}
+
+// generation is called by blocking acquisitions before every attempt, and
+// its result passed to wait if the attempt fails
+func (p *PMutex) generation() uint64 {
+	if p.opts == nil || p.opts.park == nil {
+		return 0
+	}
+
+	return p.opts.park.generation()
+}
+
+// wait is called by blocking acquisitions after a failed attempt; w
+// describes the lock state that would allow the next attempt to succeed, and
+// gen is what generation returned before the attempt
+func (p *PMutex) wait(ctx context.Context, w want, attempt int, gen uint64) {
+	switch {
+	case p.opts == nil:
+		runtime.Gosched()
+	case p.opts.queue != nil:
+		p.opts.queue.park(ctx, p, w)
+	case p.opts.futex:
+		p.sleep(ctx, w)
+	case p.opts.park != nil:
+		p.opts.park.wait(ctx, gen)
+	case p.opts.ctxWait != nil && ctx != nil && ctx.Done() != nil:
+		p.opts.ctxWait.WaitContext(ctx, attempt)
+	case p.opts.wait != nil:
+		p.opts.wait.Wait(attempt)
+	default:
+		runtime.Gosched()
+	}
+}
+
+// wake is called after the lock has been released or downgraded
+func (p *PMutex) wake() {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.waker != nil {
+		p.opts.waker.Wake()
+	}
+	if p.opts.queue != nil {
+		p.opts.queue.wake(p)
+	}
+	if p.opts.futex {
+		p.wakeSleepers()
+	}
+}
+
+// wakeParked is called after a failed attempt has rolled back the bits it
+// briefly took. Parked or sleeping waiters that checked the lock while those
+// bits were set must be given another chance
+func (p *PMutex) wakeParked() {
+	if p.opts == nil {
+		return
+	}
+	if p.opts.queue != nil {
+		p.opts.queue.wake(p)
+	}
+	if p.opts.futex {
+		p.wakeSleepers()
+	}
+}
+
+// sleepContext sleeps for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+	}
+}
+
+type yield struct{}
+
+func (yield) Wait(int) { runtime.Gosched() }
+
+// Yield returns a WaitStrategy that yields the goroutine to the scheduler
+// after every failure. This is the behaviour of the zero value PMutex
+func Yield() WaitStrategy {
+	return yield{}
+}
+
+type spinThenYield int
+
+func (s spinThenYield) Wait(attempt int) {
+	if attempt < int(s) {
+		return
+	}
+	runtime.Gosched()
+}
+
+// SpinThenYield returns a WaitStrategy that retries immediately for the
+// first n attempts, then yields like Yield. This suits locks that are only
+// ever held for very short periods
+func SpinThenYield(n int) WaitStrategy {
+	return spinThenYield(n)
+}
+
+type backoff struct {
+	min, max time.Duration
+}
+
+func (b backoff) Wait(attempt int) {
+	time.Sleep(b.delay(attempt))
+}
+
+func (b backoff) WaitContext(ctx context.Context, attempt int) {
+	sleepContext(ctx, b.delay(attempt))
+}
+
+// delay returns how long to sleep after the failed attempt
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.max
+	if attempt < 63 && b.min<<uint(attempt) < b.max && b.min<<uint(attempt) > 0 {
+		d = b.min << uint(attempt)
+	}
+
+	// sleep for somewhere between d/2 and d, so that waiters that failed
+	// together don't retry together
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Backoff returns a WaitStrategy that sleeps for an exponentially increasing,
+// jittered duration: starting at min, doubling with every failure and never
+// exceeding max
+func Backoff(min, max time.Duration) WaitStrategy {
+	if min <= 0 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return backoff{min: min, max: max}
+}
+
+type sleep time.Duration
+
+func (s sleep) Wait(int) { time.Sleep(time.Duration(s)) }
+
+func (s sleep) WaitContext(ctx context.Context, _ int) { sleepContext(ctx, time.Duration(s)) }
+
+// Sleep returns a WaitStrategy that sleeps for d after every failure
+func Sleep(d time.Duration) WaitStrategy {
+	return sleep(d)
+}
+
+type park struct {
+	// gen counts the calls to Wake. It is only changed with mu held, and
+	// comes first to be aligned for atomic access
+	gen uint64
+	max time.Duration
+
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func (p *park) Wait(attempt int) {
+	p.WaitContext(context.Background(), attempt)
+}
+
+func (p *park) WaitContext(ctx context.Context, _ int) {
+	p.wait(ctx, p.generation())
+}
+
+// generation returns the number of calls to Wake so far
+func (p *park) generation() uint64 {
+	return atomic.LoadUint64(&p.gen)
+}
+
+// wait blocks until Wake is called, ctx, if not nil, is done or max has
+// passed. It returns at once if Wake has been called since generation
+// returned gen
+func (p *park) wait(ctx context.Context, gen uint64) {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	p.mu.Lock()
+	if atomic.LoadUint64(&p.gen) != gen {
+		p.mu.Unlock()
+		return
+	}
+	if p.ch == nil {
+		p.ch = make(chan struct{})
+	}
+	ch := p.ch
+	p.mu.Unlock()
+
+	t := time.NewTimer(p.max)
+	select {
+	case <-ch:
+		t.Stop()
+	case <-done:
+		t.Stop()
+	case <-t.C:
+	}
+}
+
+func (p *park) Wake() {
+	p.mu.Lock()
+	atomic.AddUint64(&p.gen, 1)
+	if p.ch != nil {
+		close(p.ch)
+		p.ch = nil
+	}
+	p.mu.Unlock()
+}
+
+// Park returns a WaitStrategy that blocks waiters on a channel until the lock
+// is released or downgraded. A PMutex using it wakes waiters whose attempt
+// raced with a release at once; when the strategy is called directly, such a
+// release can be missed, so waiters never block for longer than max.
+// The returned strategy holds state, and should not be shared between
+// mutexes
+func Park(max time.Duration) WaitStrategy {
+	return &park{max: max}
+}
@@ -0,0 +1,198 @@
+package plock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// graphEdge is an edge of a wait-for graph: goroutine g holds, or waits for,
+// lock l in mode m. A waiter upgrading from another mode has it in from, and
+// a waiter that has claimed the lock and drains its readers is also a holder,
+// with draining set
+type graphEdge struct {
+	l        int
+	g        int64
+	m        Mode
+	from     Mode
+	draining bool
+}
+
+// WriteWaitForGraph writes the goroutines holding and waiting for locks, as
+// recorded by WithOwnership, as a Graphviz DOT graph. Waiting goroutines
+// point to the lock they wait for, which points to the goroutines holding
+// it; edges and nodes in a cycle of goroutines waiting for each other, which
+// is a deadlock, are drawn in red. If locks is empty, the registered locks
+// are used.
+//
+// Like Holders, the graph is assembled from one lock at a time, so it may be
+// inconsistent while the locks are in use. A deadlock doesn't change, though
+func WriteWaitForGraph(w io.Writer, locks ...*PMutex) error {
+	if len(locks) == 0 {
+		locks = Registered()
+	}
+
+	var holds, waits []graphEdge
+	for i, p := range locks {
+		for _, h := range p.Holders() {
+			holds = append(holds, graphEdge{l: i, g: h.Goroutine, m: h.Mode})
+		}
+		for _, wt := range p.Waiting() {
+			e := graphEdge{l: i, g: wt.Goroutine, m: wt.Mode, from: wt.From, draining: wt.Draining}
+			waits = append(waits, e)
+			if wt.Draining {
+				holds = append(holds, e)
+			}
+		}
+	}
+
+	// a waiter waits for every goroutine holding its lock in a conflicting
+	// mode. That includes itself, unless it holds the lock in the mode it
+	// upgrades from, or is the waiter draining the lock
+	blocks := func(wt, h graphEdge) bool {
+		if wt.l != h.l || !conflicts(wt.m, h.m) {
+			return false
+		}
+		return wt.g != h.g || (h.m != wt.from && !h.draining)
+	}
+	waitsFor := make(map[int64][]int64)
+	for _, wt := range waits {
+		for _, h := range holds {
+			if blocks(wt, h) {
+				waitsFor[wt.g] = append(waitsFor[wt.g], h.g)
+			}
+		}
+	}
+	cycle := cycles(waitsFor)
+
+	goroutines := make(map[int64]bool)
+	for _, e := range append(holds, waits...) {
+		goroutines[e.g] = true
+	}
+	ids := make([]int64, 0, len(goroutines))
+	for g := range goroutines {
+		ids = append(ids, g)
+	}
+	sort.Sort(int64s(ids))
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph plock {")
+	for i, p := range locks {
+		s := p.State()
+		fmt.Fprintf(b, "\tL%d [shape=box, label=%s];\n", i, labelValue(p.name()+"\n"+s.String()))
+	}
+	for _, g := range ids {
+		fmt.Fprintf(b, "\tG%d [label=\"goroutine %d\"%s];\n", g, g, red(cycle[g] != 0))
+	}
+	for _, wt := range waits {
+		inCycle := false
+		for _, h := range holds {
+			inCycle = inCycle || (blocks(wt, h) && cycle[wt.g] != 0 && cycle[wt.g] == cycle[h.g])
+		}
+		fmt.Fprintf(b, "\tG%d -> L%d [style=dashed, label=\"waits %v\"%s];\n", wt.g, wt.l, wt.m, red(inCycle))
+	}
+	for _, h := range holds {
+		inCycle := false
+		for _, wt := range waits {
+			inCycle = inCycle || (blocks(wt, h) && cycle[h.g] != 0 && cycle[wt.g] == cycle[h.g])
+		}
+		label := "held"
+		if h.draining {
+			label = "draining"
+		}
+		fmt.Fprintf(b, "\tL%d -> G%d [label=\"%s %v\"%s];\n", h.l, h.g, label, h.m, red(inCycle))
+	}
+	fmt.Fprintln(b, "}")
+
+	return b.Flush()
+}
+
+func hasEdge(edges map[int64][]int64, from, to int64) bool {
+	for _, u := range edges[from] {
+		if u == to {
+			return true
+		}
+	}
+	return false
+}
+
+// int64s sorts goroutine ids in increasing order
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func red(ok bool) string {
+	if ok {
+		return ", color=red, fontcolor=red"
+	}
+	return ""
+}
+
+// cycles finds the strongly connected components of the graph, with
+// Tarjan's algorithm, and numbers those with more than one node, or a node
+// with an edge to itself, from 1. The returned map has the component number
+// of every node in one
+func cycles(edges map[int64][]int64) map[int64]int {
+	var (
+		index   = make(map[int64]int)
+		low     = make(map[int64]int)
+		onStack = make(map[int64]bool)
+		stack   []int64
+		comp    = make(map[int64]int)
+		next    = 1
+	)
+
+	var visit func(v int64)
+	visit = func(v int64) {
+		index[v] = len(index) + 1
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, u := range edges[v] {
+			if index[u] == 0 {
+				visit(u)
+				if low[u] < low[v] {
+					low[v] = low[u]
+				}
+			} else if onStack[u] && index[u] < low[v] {
+				low[v] = index[u]
+			}
+		}
+
+		if low[v] != index[v] {
+			return
+		}
+		i := len(stack) - 1
+		for stack[i] != v {
+			i--
+		}
+		members := stack[i:]
+		stack = stack[:i]
+		for _, u := range members {
+			onStack[u] = false
+		}
+		if len(members) > 1 || hasEdge(edges, v, v) {
+			for _, u := range members {
+				comp[u] = next
+			}
+			next++
+		}
+	}
+
+	vertices := make([]int64, 0, len(edges))
+	for v := range edges {
+		vertices = append(vertices, v)
+	}
+	sort.Sort(int64s(vertices))
+	for _, v := range vertices {
+		if index[v] == 0 {
+			visit(v)
+		}
+	}
+
+	return comp
+}